@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Alextopher/apod-bot/internal/apod"
+	"github.com/bwmarrin/discordgo"
+)
+
+// birthdayHour is the hour (utc) birthday messages are sent
+const birthdayHour = 12
+
+// parseBirthday reads a birth date like ParseDate does. Unlike APOD dates it
+// can be before the first APOD, but not after today
+func parseBirthday(input string, today time.Time) (string, error) {
+	date, err := apod.ParseCalendarDate(input, today)
+	if err != nil {
+		return "", err
+	}
+
+	if date > today.Format("2006-01-02") {
+		return "", &apod.DateError{Date: input, Err: apod.ErrorDateInvalid}
+	}
+	return date, nil
+}
+
+// birthdayDay formats the month and day of a birth date, like "July 1"
+func birthdayDay(date string) string {
+	d, _ := time.Parse("2006-01-02", date)
+	return d.Format("January 2")
+}
+
+// isBirthday checks if now is the anniversary of date. February 29 birthdays
+// are celebrated on February 28 in common years
+func isBirthday(date string, now time.Time) bool {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false
+	}
+
+	month, day := d.Month(), d.Day()
+	if month == time.February && day == 29 && time.Date(now.Year(), time.March, 0, 0, 0, 0, 0, time.UTC).Day() == 28 {
+		day = 28
+	}
+	return now.Month() == month && now.Day() == day
+}
+
+// sendBirthdays sends the APODs from their birthday to every user whose birthday is today
+func (b *Bot) sendBirthdays(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, schedulerTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+
+	// Collect the users first, the db is locked while viewing
+	birthdays := make(map[string]Birthday)
+	b.db.ViewBirthdays(func(userID string, birthday Birthday) {
+		if isBirthday(birthday.Date, now) {
+			birthdays[userID] = birthday
+		}
+	})
+
+	for userID, birthday := range birthdays {
+		if err := b.sendBirthday(ctx, userID, birthday); err != nil {
+			log.Printf("birthdays: error sending to %s: %v\n", userID, err)
+		}
+	}
+}
+
+// sendBirthday sends a user the APOD from their birth date, when there is one,
+// and the ones from the same day in other years
+func (b *Bot) sendBirthday(ctx context.Context, userID string, birthday Birthday) error {
+	message := &discordgo.MessageSend{Content: "Happy birthday! 🎂"}
+	channelID := birthday.ChannelID
+	if channelID == "" {
+		channel, err := b.session.UserChannelCreate(userID)
+		if err != nil {
+			return err
+		}
+		channelID = channel.ID
+	} else {
+		message.Content = fmt.Sprintf("Happy birthday <@%s>! 🎂", userID)
+		message.AllowedMentions = &discordgo.MessageAllowedMentions{Users: []string{userID}}
+	}
+
+	// The picture from the day they were born
+	if apod.IsValidDate(birthday.Date) {
+		if embed, file, err := b.birthdayEmbed(ctx, birthday.Date); err != nil {
+			log.Println("birthdays: error getting the APOD from", birthday.Date, ":", err)
+		} else {
+			message.Embeds = append(message.Embeds, embed)
+			message.Files = append(message.Files, file)
+		}
+	}
+
+	// And every other year
+	responses, err := b.apod.OnThisDay(birthday.Date)
+	if err != nil {
+		return err
+	}
+	others := make([]*apod.Response, 0, len(responses))
+	for _, res := range responses {
+		if res.Date != birthday.Date {
+			others = append(others, res)
+		}
+	}
+	if len(others) > 0 {
+		message.Embeds = append(message.Embeds, onThisDayEmbed("On "+birthdayDay(birthday.Date)+" in other years", others))
+	}
+
+	_, err = b.session.ChannelMessageSendComplex(channelID, message)
+	return err
+}
+
+// birthdayEmbed creates the embed for the APOD from a birth date
+func (b *Bot) birthdayEmbed(ctx context.Context, date string) (*discordgo.MessageEmbed, *discordgo.File, error) {
+	res, err := apod.Retry(ctx, apod.SchedulerRetry, func(ctx context.Context) (*apod.Response, error) {
+		return b.apod.GetContext(ctx, date)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return b.ToEmbed(ctx, res)
+}
@@ -1,19 +1,31 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/Alextopher/apod-bot/internal/apod"
 	"github.com/bwmarrin/discordgo"
 )
 
-const discordMaxImageSize = 8 * 1024 * 1024
+// discordProfile is the image size every server can upload
+const discordProfile = apod.Profile8MB
+
+// schedulerTimeout is how long the scheduler may spend waiting for NASA each
+// hour, and then again preparing the message
+const schedulerTimeout = 10 * time.Minute
 
 // Bot is the discord bot
 type Bot struct {
-	db   *DB
-	apod *apod.APOD
+	db       *DB
+	apod     *apod.APOD
+	backfill *apod.Backfill
 
 	session *discordgo.Session
 	owner   *discordgo.User
@@ -29,6 +41,11 @@ func (b *Bot) SetOwner(ownerID string) error {
 	return nil
 }
 
+// IsOwner checks if a user is the bot's owner
+func (b *Bot) IsOwner(user *discordgo.User) bool {
+	return b.owner != nil && user != nil && user.ID == b.owner.ID
+}
+
 // MessageOwner sends a message to the bot's owner
 func (b *Bot) MessageOwner(msg string) error {
 	if b.owner == nil {
@@ -49,9 +66,10 @@ func (b *Bot) MessageOwner(msg string) error {
 }
 
 // Schedule adds a job to the scheduler to send an APOD message to a channel
-// at a specific hour of the day (in UTC)
-func (b *Bot) Schedule(channel string, hour int) {
-	b.db.Set(channel, hour)
+// at a specific hour of the day (in UTC), optionally with the APODs from one
+// and ten years ago
+func (b *Bot) Schedule(channel string, hour int, history bool) {
+	b.db.Set(channel, hour, history)
 }
 
 // Stop removes a server from the scheduler
@@ -75,47 +93,141 @@ func (b *Bot) UpdateSchedule() {
 }
 
 // RunScheduler runs the scheduler, checking every hour on the hour if it needs
-// to send an APOD message
-func (b *Bot) RunScheduler() {
+// to send an APOD message, and sending birthday messages once a day. It
+// returns once ctx is done
+func (b *Bot) RunScheduler(ctx context.Context) {
 	b.UpdateSchedule()
 	for {
-		sleepUntilNextHour()
-		time.Sleep(time.Minute)
+		if sleepUntilNextHour(ctx) != nil {
+			return
+		}
+
+		b.runScheduledHour(ctx)
+		if time.Now().UTC().Hour() == birthdayHour {
+			b.sendBirthdays(ctx)
+		}
+	}
+}
+
+// runScheduledHour sends today's APOD to every channel scheduled for the current hour
+func (b *Bot) runScheduledHour(ctx context.Context) {
+	// Get today's APOD with retries, waiting a while for NASA to publish
+	// today's picture if the publishing day just rolled over
+	retryCtx, cancel := context.WithTimeout(ctx, schedulerTimeout)
+	res, err := apod.Retry(retryCtx, apod.SchedulerRetry, b.apod.PublishedTodayContext)
+	cancel()
+
+	if errors.Is(err, apod.ErrorNotPublished) {
+		log.Println("scheduler: today's APOD is not up yet, sending", res.Date)
+	} else if err != nil {
+		log.Println("scheduler: error getting today's APOD:", err)
+		return
+	}
+
+	// A late APOD may have used up the wait, the image gets a budget of its own
+	ctx, cancel = context.WithTimeout(ctx, schedulerTimeout)
+	defer cancel()
 
-		// Prepare the message with retries
-		res, err := apod.Retry(func() (*apod.Response, error) {
-			return b.apod.Today()
+	embed, file, err := b.ToEmbed(ctx, res)
+	if err != nil {
+		log.Println("scheduler: error creating embed for", res.Date, ":", err)
+		return
+	}
+
+	// Every channel is sent its own reader over the same image
+	image, err := io.ReadAll(file.Reader)
+	if err != nil {
+		log.Println("scheduler: error reading image for", res.Date, ":", err)
+		return
+	}
+
+	// Channels can ask for the APODs from years past too
+	historyEmbed := embed
+	if field := b.historyField(res.Date); field != nil {
+		copied := *embed
+		copied.Fields = append(append([]*discordgo.MessageEmbedField{}, embed.Fields...), field)
+		historyEmbed = &copied
+	}
+
+	// Collect the channels first, sending records to the db which needs the write lock
+	hour := time.Now().UTC().Hour()
+	var channels []string
+	b.db.View(func(channelID string, hourToSend int) {
+		if hour == hourToSend {
+			channels = append(channels, channelID)
+		}
+	})
+
+	for _, channelID := range channels {
+		log.Printf("scheduler: sending APOD to %s\n", channelID)
+
+		send := embed
+		if b.db.History(channelID) {
+			send = historyEmbed
+		}
+
+		_, err = b.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
+			Embeds: []*discordgo.MessageEmbed{send},
+			Files: []*discordgo.File{{
+				Name:        file.Name,
+				ContentType: file.ContentType,
+				Reader:      bytes.NewReader(image),
+			}},
 		})
 
 		if err != nil {
-			log.Println("scheduler: error getting today's APOD:", err)
-			continue
+			log.Println("scheduler: error sending message:", err)
+		} else {
+			b.db.Sent(channelID, res.Date)
 		}
+	}
+}
 
-		embed, file := b.ToEmbed(res)
-
-		hour := time.Now().UTC().Hour()
-		b.db.View(func(channelID string, hourToSend int) {
-			if hour == hourToSend {
-				log.Printf("scheduler: sending APOD to %s\n", channelID)
+// historyYears are the anniversaries shown under scheduled APODs
+var historyYears = []struct {
+	years int
+	label string
+}{
+	{1, "One year ago"},
+	{10, "Ten years ago"},
+}
 
-				_, err = b.session.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{
-					Embeds: []*discordgo.MessageEmbed{embed},
-					Files:  []*discordgo.File{file},
-				})
+// historyField links to the cached APODs from one and ten years before date,
+// nil if neither is cached
+func (b *Bot) historyField(date string) *discordgo.MessageEmbedField {
+	var lines []string
+	for _, h := range historyYears {
+		ago, ok := apod.YearsAgo(date, h.years)
+		if !ok {
+			continue
+		}
+		if res, ok := b.apod.Cached(ago); ok {
+			lines = append(lines, fmt.Sprintf("%s: [%s](%s)", h.label, res.Title, archiveURL(ago)))
+		}
+	}
 
-				if err != nil {
-					log.Println("scheduler: error sending message:", err)
-				} else {
-					b.db.Sent(channelID, res.Date)
-				}
-			}
-		})
+	if len(lines) == 0 {
+		return nil
+	}
+	return &discordgo.MessageEmbedField{
+		Name:  "On this day",
+		Value: strings.Join(lines, "\n"),
 	}
 }
 
-func sleepUntilNextHour() {
+// sleepUntilNextHour sleeps until a minute past the next hour, returning early
+// with ctx's error if ctx is done first
+func sleepUntilNextHour(ctx context.Context) error {
 	now := time.Now().UTC()
-	next := time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, time.UTC)
-	time.Sleep(next.Sub(now))
+	next := time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 1, 0, 0, time.UTC)
+
+	timer := time.NewTimer(next.Sub(now))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
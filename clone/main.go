@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"os"
+	"os/signal"
 
 	"github.com/Alextopher/apod-bot/internal/apod"
 	"github.com/Alextopher/apod-bot/internal/cache"
@@ -10,10 +13,16 @@ import (
 )
 
 func main() {
+	images := flag.Bool("images", false, "also download every image")
+	workers := flag.Int("workers", apod.DefaultBackfillOptions.Workers, "number of parallel range requests")
+	flag.Parse()
+
 	godotenv.Load()
 
 	// Load tokens from .env file.
 	apodToken := os.Getenv("APOD_TOKEN")
+	// Additional comma separated NASA API keys to rotate through
+	apodTokens := apod.ParseKeys(os.Getenv("APOD_TOKENS"))
 
 	// Connect to APOD API
 	cacheFile, err := os.OpenFile("apod.cache", os.O_RDWR|os.O_CREATE, 0644)
@@ -28,9 +37,61 @@ func main() {
 		return
 	}
 
+	// Dates with no APOD
+	missingPath, err := cache.DataPath("apod.missing")
+	if err != nil {
+		log.Println("Error moving apod.missing: ", err)
+		return
+	}
+
+	missingFile, err := os.OpenFile(missingPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println("Error opening apod.missing: ", err)
+		return
+	}
+
+	missingCache, err := cache.NewAppendCache[*apod.MissingDate](missingFile, missingFile)
+	if err != nil {
+		log.Println("Error creating missing dates cache: ", err)
+		return
+	}
+
+	// Topic tags of each APOD
+	tagPath, err := cache.DataPath("apod.tags")
+	if err != nil {
+		log.Println("Error moving apod.tags: ", err)
+		return
+	}
+
+	tagFile, err := os.OpenFile(tagPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println("Error opening apod.tags: ", err)
+		return
+	}
+
+	tagCache, err := cache.NewAppendCache[*apod.Tags](tagFile, tagFile)
+	if err != nil {
+		log.Println("Error creating tag cache: ", err)
+		return
+	}
+
 	imageCache := apod.NewImageCache("images")
-	a := apod.NewClient(apodToken, apodCache, imageCache)
+	a := apod.NewClient(apodToken, apodCache, imageCache, apod.WithKeys(apodTokens...), apod.WithMissingCache(missingCache), apod.WithTagCache(tagCache))
+
+	// Get all apods from 1995-06-16 to today, stopping early on CTRL-C.
+	// Progress is saved to data/backfill.json so that a restart picks up failures
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if _, err := cache.DataPath("backfill.json"); err != nil {
+		log.Println("Error moving backfill.json: ", err)
+		return
+	}
+
+	options := apod.DefaultBackfillOptions
+	options.Images = *images
+	options.Workers = *workers
+	options.State = cache.NewLocalFS(cache.DataDir)
 
-	// Get all apods from 1995-06-16 to today
-	a.Fill()
+	apod.NewBackfill(a, options).Run(ctx)
 }
@@ -2,9 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Alextopher/apod-bot/internal/apod"
 	"github.com/bwmarrin/discordgo"
@@ -14,7 +17,8 @@ import (
 const bitmask = discordgo.PermissionManageServer | discordgo.PermissionAdministrator
 
 var (
-	zero = float64(0)
+	zero      = float64(0)
+	firstYear = float64(apod.FirstDate.Year())
 )
 
 const (
@@ -22,6 +26,30 @@ const (
 	ephemeral = discordgo.MessageFlagsEphemeral
 )
 
+// tagChoices offers every topic tag as a command option
+var tagChoices = func() []*discordgo.ApplicationCommandOptionChoice {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, tag := range apod.TagNames() {
+		choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: tag, Value: tag})
+	}
+	return choices
+}()
+
+// commandTimeout is how long a command may spend waiting on NASA before giving up
+const commandTimeout = 30 * time.Second
+
+// searchResults is how many matches /search shows
+const searchResults = 5
+
+// snippetLength is how much of an explanation is shown next to a search result
+const snippetLength = 120
+
+// relatedLinks is how many related APODs are linked under an embed
+const relatedLinks = 3
+
+// creditsPage is how many APODs /credits lists at a time
+const creditsPage = 10
+
 var commands = []*discordgo.ApplicationCommand{
 	{
 		Name:        "today",
@@ -32,6 +60,35 @@ var commands = []*discordgo.ApplicationCommand{
 		Name:        "random",
 		Description: "Get a random APOD",
 		Type:        discordgo.ChatApplicationCommand,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "from_year",
+				Description: "The earliest year to pick from",
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				MinValue:    &firstYear,
+			},
+			{
+				Name:        "to_year",
+				Description: "The latest year to pick from",
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				MinValue:    &firstYear,
+			},
+			{
+				Name:        "media",
+				Description: "Only pick images or only pick videos",
+				Type:        discordgo.ApplicationCommandOptionString,
+				Choices: []*discordgo.ApplicationCommandOptionChoice{
+					{Name: "Images", Value: "image"},
+					{Name: "Videos", Value: "video"},
+				},
+			},
+			{
+				Name:        "tag",
+				Description: "Only pick APODs about a topic",
+				Type:        discordgo.ApplicationCommandOptionString,
+				Choices:     tagChoices,
+			},
+		},
 	},
 	{
 		Name:        "specific",
@@ -39,11 +96,99 @@ var commands = []*discordgo.ApplicationCommand{
 		Type:        discordgo.ChatApplicationCommand,
 		Options: []*discordgo.ApplicationCommandOption{{
 			Name:        "date",
-			Description: "In yyyy-mm-dd format",
+			Description: "Like 2021-07-01, July 1 2021, yesterday, or an apod.nasa.gov link",
 			Type:        discordgo.ApplicationCommandOptionString,
 			Required:    true,
 		}},
 	},
+	{
+		Name:        "search",
+		Description: "Search the APOD archive",
+		Type:        discordgo.ChatApplicationCommand,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "query",
+				Description: `Words to look for, "quoted phrases" must match exactly, tag:nebula limits to a topic`,
+				Type:        discordgo.ApplicationCommandOptionString,
+			},
+			{
+				Name:        "from_year",
+				Description: "The earliest year to search",
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				MinValue:    &firstYear,
+			},
+			{
+				Name:        "to_year",
+				Description: "The latest year to search",
+				Type:        discordgo.ApplicationCommandOptionInteger,
+				MinValue:    &firstYear,
+			},
+			{
+				Name:        "tag",
+				Description: "Only search APODs about a topic",
+				Type:        discordgo.ApplicationCommandOptionString,
+				Choices:     tagChoices,
+			},
+		},
+	},
+	{
+		Name:        "credits",
+		Description: "List the APODs credited to a photographer",
+		Type:        discordgo.ChatApplicationCommand,
+		Options: []*discordgo.ApplicationCommandOption{{
+			Name:         "name",
+			Description:  "The photographer's name",
+			Type:         discordgo.ApplicationCommandOptionString,
+			Required:     true,
+			Autocomplete: true,
+		}},
+	},
+	{
+		Name:        "onthisday",
+		Description: "List the APODs from the same day in every year",
+		Type:        discordgo.ChatApplicationCommand,
+		Options: []*discordgo.ApplicationCommandOption{{
+			Name:        "date",
+			Description: "The day to look up, today if not set",
+			Type:        discordgo.ApplicationCommandOptionString,
+		}},
+	},
+	{
+		Name:        "birthday",
+		Description: "Get the APODs from your birthday every year",
+		Type:        discordgo.ChatApplicationCommand,
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Name:        "set",
+				Description: "Save your birthday, I'll send you its APODs on the day",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Name:        "date",
+						Description: "Your birth date, like 1990-07-01",
+						Type:        discordgo.ApplicationCommandOptionString,
+						Required:    true,
+					},
+					{
+						Name:         "channel",
+						Description:  "Post in this channel instead of sending a direct message",
+						Type:         discordgo.ApplicationCommandOptionChannel,
+						ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+					},
+				},
+			},
+			{
+				Name:        "show",
+				Description: "Show the birthday I have saved for you",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+			},
+			{
+				Name:        "delete",
+				Description: "Stop the birthday messages and delete your birthday",
+				Type:        discordgo.ApplicationCommandOptionSubCommand,
+			},
+		},
+	},
 	{
 		Name:        "explanation",
 		Description: "Get the explanation of the last APOD",
@@ -60,6 +205,10 @@ var commands = []*discordgo.ApplicationCommand{
 			MinValue:    &zero,
 			MaxValue:    23,
 			Required:    true,
+		}, {
+			Name:        "history",
+			Description: "Also link the APODs from one and ten years ago",
+			Type:        discordgo.ApplicationCommandOptionBoolean,
 		}},
 	},
 	{
@@ -72,48 +221,277 @@ var commands = []*discordgo.ApplicationCommand{
 		Description: "Visit the bot's github repo",
 		Type:        discordgo.ChatApplicationCommand,
 	},
+	{
+		Name:        "status",
+		Description: "Show the bot's NASA API budget and backfill progress (owner only)",
+		Type:        discordgo.ChatApplicationCommand,
+	},
 }
 
 // Responds to an interaction with an APOD
-func (bot *Bot) get(msg *Response, resp *apod.Response) {
-	embed, file := bot.ToEmbed(resp)
-	if embed == nil || file == nil {
-		msg.TextMessage("Error creating embed", ephemeral)
+func (bot *Bot) get(ctx context.Context, msg *Response, resp *apod.Response) {
+	embed, file, err := bot.ToEmbed(ctx, resp)
+	if err != nil {
+		log.Println("Error creating embed for", resp.Date, ":", err)
+		msg.TextMessage(errorMessage(msg.interaction.Locale, err), ephemeral)
 		return
 	}
 
 	bot.db.Sent(msg.interaction.ChannelID, resp.Date)
-	err := msg.EmbedMessage(embed, file, none)
+	err = msg.EmbedMessage(embed, file, none)
 	if err != nil {
 		log.Println("Error sending message:", err)
 	}
 }
 
+// commandError logs an error from the APOD client and tells the user what went wrong
+func (bot *Bot) commandError(msg *Response, err error) {
+	log.Println("Error handling command:", err)
+	msg.TextMessage(errorMessage(msg.interaction.Locale, err), ephemeral)
+}
+
+// canPost checks that channelID is a text channel the member that created an
+// interaction may send messages in, so the bot doesn't post for them where
+// they can't
+func canPost(s *discordgo.Session, i *discordgo.Interaction, channelID string) bool {
+	data := i.ApplicationCommandData()
+	if i.Member == nil || data.Resolved == nil {
+		return false
+	}
+	if channel, ok := data.Resolved.Channels[channelID]; !ok || channel.Type != discordgo.ChannelTypeGuildText {
+		return false
+	}
+
+	// The state works the permissions out from the guild's roles and the
+	// channel's overwrites, it only lacks the member
+	member := *i.Member
+	member.GuildID = i.GuildID
+	if err := s.State.MemberAdd(&member); err != nil {
+		log.Println("Error adding member to state:", err)
+		return false
+	}
+
+	permissions, err := s.State.UserChannelPermissions(member.User.ID, channelID)
+	if err != nil {
+		log.Println("Error getting channel permissions:", err)
+		return false
+	}
+
+	const needed = discordgo.PermissionViewChannel | discordgo.PermissionSendMessages
+	return permissions&needed == needed
+}
+
+// interactionUser returns the user that created an interaction, in a guild or a DM
+func interactionUser(i *discordgo.Interaction) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// interactionHandler handles every interaction, switching on its type
+func (bot *Bot) interactionHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	switch i.Type {
+	case discordgo.InteractionApplicationCommand:
+		bot.commandHandler(s, i)
+	case discordgo.InteractionMessageComponent:
+		bot.componentHandler(s, i)
+	case discordgo.InteractionApplicationCommandAutocomplete:
+		bot.autocompleteHandler(s, i)
+	}
+}
+
+// autocompleteHandler suggests values for the option being typed
+func (bot *Bot) autocompleteHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	var choices []*discordgo.ApplicationCommandOptionChoice
+	for _, option := range i.ApplicationCommandData().Options {
+		if !option.Focused {
+			continue
+		}
+
+		switch option.Name {
+		case "name":
+			// Discord shows at most 25 choices
+			for _, name := range bot.apod.Credits().Complete(option.StringValue(), 25) {
+				choices = append(choices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+			}
+		}
+	}
+
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionApplicationCommandAutocompleteResult,
+		Data: &discordgo.InteractionResponseData{
+			Choices: choices,
+		},
+	})
+	if err != nil {
+		log.Println("Error sending autocomplete choices: ", err)
+	}
+}
+
+// componentHandler handles buttons, their custom IDs are "action:value"
+func (bot *Bot) componentHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	log.Println("Component: ", i.MessageComponentData().CustomID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	action, value, _ := strings.Cut(i.MessageComponentData().CustomID, ":")
+	switch action {
+	case "post":
+		msg := NewResponse(s, i.Interaction, none)
+		resp, err := apod.Retry(ctx, apod.InteractiveRetry, func(ctx context.Context) (*apod.Response, error) {
+			return bot.apod.GetContext(ctx, value)
+		})
+		if err != nil {
+			bot.commandError(msg, err)
+			return
+		}
+		bot.get(ctx, msg, resp)
+	case "credits":
+		// "credits:page:name"
+		page, name, _ := strings.Cut(value, ":")
+		n, _ := strconv.Atoi(page)
+		content, components := bot.creditsMessage(name, n)
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseUpdateMessage,
+			Data: &discordgo.InteractionResponseData{
+				Content:    content,
+				Components: components,
+			},
+		})
+		if err != nil {
+			log.Println("Error updating credits: ", err)
+		}
+	default:
+		log.Println("Unknown component: ", i.MessageComponentData().CustomID)
+	}
+}
+
+// creditsMessage lists a page of the APODs credited to name, with buttons to turn the page
+func (bot *Bot) creditsMessage(name string, page int) (string, []discordgo.MessageComponent) {
+	name, dates := bot.apod.Credits().Dates(name)
+	if len(dates) == 0 {
+		return fmt.Sprintf("I don't know of any APODs credited to %s.", name), []discordgo.MessageComponent{}
+	}
+
+	pages := (len(dates) + creditsPage - 1) / creditsPage
+	page = max(0, min(page, pages-1))
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "**%s** is credited on %d APODs (page %d/%d)\n", name, len(dates), page+1, pages)
+	for _, date := range dates[page*creditsPage : min((page+1)*creditsPage, len(dates))] {
+		if resp, ok := bot.apod.Cached(date); ok {
+			fmt.Fprintf(&content, "- [%s](%s) (%s)\n", resp.Title, archiveURL(date), date)
+		}
+	}
+
+	// Custom IDs are limited to 100 characters
+	if pages == 1 || len(name) > 80 {
+		return content.String(), []discordgo.MessageComponent{}
+	}
+	return content.String(), []discordgo.MessageComponent{discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+		discordgo.Button{
+			Label:    "Previous",
+			Style:    discordgo.SecondaryButton,
+			CustomID: fmt.Sprintf("credits:%d:%s", page-1, name),
+			Disabled: page == 0,
+		},
+		discordgo.Button{
+			Label:    "Next",
+			Style:    discordgo.SecondaryButton,
+			CustomID: fmt.Sprintf("credits:%d:%s", page+1, name),
+			Disabled: page == pages-1,
+		},
+	}}}
+}
+
+// searchMessage lists search results with a button to post each one
+func searchMessage(results []apod.Result) (string, []discordgo.MessageComponent) {
+	var content strings.Builder
+	var buttons []discordgo.MessageComponent
+	for n, result := range results {
+		fmt.Fprintf(&content, "%d. **%s** (%s)\n> %s\n", n+1, result.Response.Title, result.Response.Date, snippet(result.Response.Explanation, snippetLength))
+		buttons = append(buttons, discordgo.Button{
+			Label:    fmt.Sprintf("Post %d", n+1),
+			Style:    discordgo.PrimaryButton,
+			CustomID: "post:" + result.Response.Date,
+		})
+	}
+	return content.String(), []discordgo.MessageComponent{discordgo.ActionsRow{Components: buttons}}
+}
+
+// onThisDayEmbed lists APODs from the same day in different years
+func onThisDayEmbed(title string, responses []*apod.Response) *discordgo.MessageEmbed {
+	embed := &discordgo.MessageEmbed{
+		Title: title,
+		Color: 0xFF0000,
+	}
+	if len(responses) == 0 {
+		embed.Description = "I don't have any APODs from this day yet."
+	}
+	for _, res := range responses {
+		embed.Description += fmt.Sprintf("%s: [%s](%s)\n", res.Date[:4], res.Title, archiveURL(res.Date))
+	}
+	return embed
+}
+
+// snippet shortens text to at most n runes, cutting at a word
+func snippet(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+
+	cut := string(runes[:n])
+	if space := strings.LastIndex(cut, " "); space > 0 {
+		cut = cut[:space]
+	}
+	return cut + "…"
+}
+
 // commandHandler handles application commands, switching on the command name
 func (bot *Bot) commandHandler(s *discordgo.Session, i *discordgo.InteractionCreate) {
 	log.Println("Command: ", i.ApplicationCommandData().Name)
 
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
 	switch i.ApplicationCommandData().Name {
 	case "today":
 		msg := NewResponse(s, i.Interaction, none)
-		resp, err := apod.Retry(func() (*apod.Response, error) {
-			return bot.apod.Today()
-		})
+		resp, err := apod.Retry(ctx, apod.InteractiveRetry, bot.apod.TodayContext)
 		if err != nil {
-			msg.TextMessage("Error getting random APOD: "+err.Error(), ephemeral)
+			bot.commandError(msg, err)
 			return
 		}
-		bot.get(msg, resp)
+		bot.get(ctx, msg, resp)
 	case "random":
 		msg := NewResponse(s, i.Interaction, none)
-		resp, err := apod.Retry(func() (*apod.Response, error) {
-			return bot.apod.Random()
+
+		// Only pick APODs that can be turned into an embed
+		filter := apod.RandomFilter{ImagesOnly: true}
+		for _, option := range i.ApplicationCommandData().Options {
+			switch option.Name {
+			case "from_year":
+				filter.FromYear = int(option.Value.(float64))
+			case "to_year":
+				filter.ToYear = int(option.Value.(float64))
+			case "media":
+				filter.MediaType = option.Value.(string)
+			case "tag":
+				filter.Tags = append(filter.Tags, option.Value.(string))
+			}
+		}
+
+		resp, err := apod.Retry(ctx, apod.InteractiveRetry, func(ctx context.Context) (*apod.Response, error) {
+			return bot.apod.RandomWith(ctx, filter)
 		})
 		if err != nil {
-			msg.TextMessage("Error getting random APOD: "+err.Error(), ephemeral)
+			bot.commandError(msg, err)
 			return
 		}
-		bot.get(msg, resp)
+		bot.get(ctx, msg, resp)
 	case "specific":
 		msg := NewResponse(s, i.Interaction, none)
 
@@ -124,14 +502,140 @@ func (bot *Bot) commandHandler(s *discordgo.Session, i *discordgo.InteractionCre
 			}
 		}
 
-		resp, err := apod.Retry(func() (*apod.Response, error) {
-			return bot.apod.Get(date)
+		date, err := bot.apod.ResolveDate(date)
+		if err != nil {
+			bot.commandError(msg, err)
+			return
+		}
+
+		resp, err := apod.Retry(ctx, apod.InteractiveRetry, func(ctx context.Context) (*apod.Response, error) {
+			return bot.apod.GetContext(ctx, date)
 		})
 		if err != nil {
-			msg.TextMessage("Error getting random APOD: "+err.Error(), ephemeral)
+			bot.commandError(msg, err)
+			return
+		}
+		bot.get(ctx, msg, resp)
+	case "search":
+		msg := NewResponse(s, i.Interaction, ephemeral)
+
+		var query apod.Query
+		var fromYear, toYear int
+		var tag string
+		for _, option := range i.ApplicationCommandData().Options {
+			switch option.Name {
+			case "query":
+				query = apod.ParseQuery(option.Value.(string))
+			case "from_year":
+				fromYear = int(option.Value.(float64))
+			case "to_year":
+				toYear = int(option.Value.(float64))
+			case "tag":
+				tag = option.Value.(string)
+			}
+		}
+		if tag != "" {
+			query.Tags = append(query.Tags, tag)
+		}
+		if fromYear != 0 {
+			query.From = fmt.Sprintf("%04d-01-01", fromYear)
+		}
+		if toYear != 0 {
+			query.To = fmt.Sprintf("%04d-12-31", toYear)
+		}
+
+		results := bot.apod.SearchQuery(query, searchResults)
+		if len(results) == 0 {
+			msg.TextMessage("No APODs match that search.", ephemeral)
 			return
 		}
-		bot.get(msg, resp)
+
+		content, components := searchMessage(results)
+		msg.ComponentMessage(content, components, ephemeral)
+	case "credits":
+		msg := NewResponse(s, i.Interaction, ephemeral)
+
+		var name string
+		for _, option := range i.ApplicationCommandData().Options {
+			if option.Name == "name" {
+				name = option.StringValue()
+			}
+		}
+
+		content, components := bot.creditsMessage(name, 0)
+		msg.ComponentMessage(content, components, ephemeral)
+	case "onthisday":
+		msg := NewResponse(s, i.Interaction, none)
+
+		date := bot.apod.TodayDate()
+		for _, option := range i.ApplicationCommandData().Options {
+			if option.Name == "date" {
+				resolved, err := bot.apod.ResolveDate(option.StringValue())
+				if err != nil {
+					bot.commandError(msg, err)
+					return
+				}
+				date = resolved
+			}
+		}
+
+		responses, err := bot.apod.OnThisDay(date)
+		if err != nil {
+			bot.commandError(msg, err)
+			return
+		}
+
+		day, _ := time.Parse("2006-01-02", date)
+		msg.EmbedMessage(onThisDayEmbed("On "+day.Format("January 2"), responses), nil, none)
+	case "birthday":
+		msg := NewResponse(s, i.Interaction, ephemeral)
+		user := interactionUser(i.Interaction)
+
+		sub := i.ApplicationCommandData().Options[0]
+		switch sub.Name {
+		case "set":
+			var birthday Birthday
+			for _, option := range sub.Options {
+				switch option.Name {
+				case "date":
+					date, err := parseBirthday(option.StringValue(), time.Now().UTC())
+					if err != nil {
+						msg.TextMessage(errorMessage(i.Locale, err), ephemeral)
+						return
+					}
+					birthday.Date = date
+				case "channel":
+					channelID := option.ChannelValue(nil).ID
+					if !canPost(s, i.Interaction, channelID) {
+						msg.TextMessage("You can only pick a text channel you can send messages in", ephemeral)
+						return
+					}
+					birthday.ChannelID = channelID
+				}
+			}
+
+			bot.db.SetBirthday(user.ID, birthday)
+			msg.TextMessage(fmt.Sprintf("I'll send you the APODs from your birthday every year on %s. Use `/birthday delete` to stop and delete your birthday", birthdayDay(birthday.Date)), ephemeral)
+		case "show":
+			birthday, ok := bot.db.GetBirthday(user.ID)
+			if !ok {
+				msg.TextMessage("I don't have a birthday saved for you. Use `/birthday set` to save one", ephemeral)
+				return
+			}
+
+			where := "by direct message"
+			if birthday.ChannelID != "" {
+				where = "in <#" + birthday.ChannelID + ">"
+			}
+			msg.TextMessage(fmt.Sprintf("Your birthday is %s, I'll send its APODs %s", birthday.Date, where), ephemeral)
+		case "delete":
+			if err := bot.db.Forget(user.ID); err != nil {
+				log.Println("Error compacting the database:", err)
+				msg.TextMessage("You won't get any more birthday messages, but I couldn't delete your birthday yet. Please try again later.", ephemeral)
+				return
+			}
+			msg.TextMessage("Your birthday has been deleted and you won't get any more birthday messages.", ephemeral)
+		}
 	case "explanation":
 		// Get the last APOD sent to this channel
 		var apod *apod.Response
@@ -139,19 +643,13 @@ func (bot *Bot) commandHandler(s *discordgo.Session, i *discordgo.InteractionCre
 
 		msg := NewResponse(s, i.Interaction, none)
 		if date, ok := bot.db.GetLast(i.ChannelID); ok {
-			apod, err = bot.apod.Get(date)
-			if err != nil {
-				msg.TextMessage("Sorry, I couldn't get the explanation for the last APOD", ephemeral)
-				log.Println("Error getting explanation: ", err)
-				return
-			}
+			apod, err = bot.apod.GetContext(ctx, date)
 		} else {
-			apod, err = bot.apod.Today()
-			if err != nil {
-				msg.TextMessage("Sorry, I couldn't get the explanation for today's APOD", ephemeral)
-				log.Println("Error getting explanation: ", err)
-				return
-			}
+			apod, err = bot.apod.TodayContext(ctx)
+		}
+		if err != nil {
+			bot.commandError(msg, err)
+			return
 		}
 
 		msg.TextMessage(apod.CreateExplanation(), none)
@@ -164,14 +662,19 @@ func (bot *Bot) commandHandler(s *discordgo.Session, i *discordgo.InteractionCre
 			return
 		}
 
+		var hour int
+		var history bool
 		for _, option := range i.ApplicationCommandData().Options {
-			if option.Name == "hour" {
-				hour := int(option.Value.(float64))
-				bot.db.Set(i.ChannelID, hour)
-				msg.TextMessage(fmt.Sprintf("Astronomy picture of the day will be sent daily at %d:00 UTC. Use `/stop` to stop", hour), none)
-				return
+			switch option.Name {
+			case "hour":
+				hour = int(option.Value.(float64))
+			case "history":
+				history = option.BoolValue()
 			}
 		}
+
+		bot.Schedule(i.ChannelID, hour, history)
+		msg.TextMessage(fmt.Sprintf("Astronomy picture of the day will be sent daily at %d:00 UTC. Use `/stop` to stop", hour), none)
 	case "stop":
 		msg := NewResponse(s, i.Interaction, ephemeral)
 
@@ -186,6 +689,28 @@ func (bot *Bot) commandHandler(s *discordgo.Session, i *discordgo.InteractionCre
 	case "source":
 		msg := NewResponse(s, i.Interaction, none)
 		msg.TextMessage("https://github.com/Alextopher/apod-bot", none)
+	case "status":
+		msg := NewResponse(s, i.Interaction, ephemeral)
+
+		if !bot.IsOwner(interactionUser(i.Interaction)) {
+			msg.TextMessage("Only the bot's owner can use this command.", ephemeral)
+			return
+		}
+
+		status := fmt.Sprintf("NASA API budget: %s", bot.apod.RateLimit())
+		for _, key := range bot.apod.Keys() {
+			status += "\n- " + key.String()
+		}
+
+		progress := bot.backfill.Progress()
+		status += fmt.Sprintf("\nBackfill: %d/%d days, %d remaining, %d failed", progress.Done, progress.Total, progress.Remaining(), len(progress.Failed))
+		if progress.Running {
+			status += fmt.Sprintf(", running since %s", progress.Started.Format(time.RFC1123))
+		}
+		if progress.Images > 0 || progress.ImagesFailed > 0 {
+			status += fmt.Sprintf("\nImages: %d downloaded, %d failed", progress.Images, progress.ImagesFailed)
+		}
+		msg.TextMessage(status, ephemeral)
 	default:
 		log.Println("Unknown command: ", i.ApplicationCommandData().Name)
 		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
@@ -200,30 +725,28 @@ func (bot *Bot) commandHandler(s *discordgo.Session, i *discordgo.InteractionCre
 	}
 }
 
-// ToEmbed creates a discordgo.MessageEmbed from an APOD response
-func (bot *Bot) ToEmbed(a *apod.Response) (*discordgo.MessageEmbed, *discordgo.File) {
-	// Get the image and resize it for discord
-	image, err := bot.apod.GetImage(a.Date)
-	if err != nil {
-		log.Println("Error getting image for", a.Date, ":", err)
-		return nil, nil
-	}
+// archiveURL links to the apod.nasa.gov page of a date
+func archiveURL(date string) string {
+	// date is in the format yyyy-mm-dd
+	// but the url format is apyymmdd
+	return fmt.Sprintf("https://apod.nasa.gov/apod/ap%s.html", strings.Replace(date, "-", "", -1)[2:])
+}
 
-	err = image.Resize(discordMaxImageSize)
+// ToEmbed creates a discordgo.MessageEmbed from an APOD response
+func (bot *Bot) ToEmbed(ctx context.Context, a *apod.Response) (*discordgo.MessageEmbed, *discordgo.File, error) {
+	// Get the image at a size discord accepts
+	image, err := bot.apod.GetImageVariant(ctx, a.Date, discordProfile)
 	if err != nil {
-		log.Println("Error resizing image for", a.Date, ":", err)
-		return nil, nil
+		return nil, nil, fmt.Errorf("getting image: %w", err)
 	}
 
 	embed := &discordgo.MessageEmbed{
 		Title: a.Title,
 		Color: 0xFF0000,
 		Author: &discordgo.MessageEmbedAuthor{
-			Name: a.Copyright,
+			Name: strings.Join(apod.Contributors(a.Copyright), ", "),
 		},
-		// a.Date is in the format yyyy-mm-dd
-		// but the url format is apyymmdd
-		Description: fmt.Sprintf("[%s](https://apod.nasa.gov/apod/ap%s.html)\n", a.Date, strings.Replace(a.Date, "-", "", -1)[2:]),
+		Description: fmt.Sprintf("[%s](%s)\n", a.Date, archiveURL(a.Date)),
 	}
 
 	filename := fmt.Sprintf("%s.%s", a.Date, image.Format)
@@ -239,8 +762,19 @@ func (bot *Bot) ToEmbed(a *apod.Response) (*discordgo.MessageEmbed, *discordgo.F
 		}
 	}
 
+	if related := bot.apod.Related(a.Date, relatedLinks); len(related) > 0 {
+		var links []string
+		for _, result := range related {
+			links = append(links, fmt.Sprintf("[%s](%s)", result.Response.Title, archiveURL(result.Response.Date)))
+		}
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  "Related",
+			Value: strings.Join(links, "\n"),
+		})
+	}
+
 	return embed, &discordgo.File{
 		Name:   filename,
 		Reader: bytes.NewReader(image.Bytes),
-	}
+	}, nil
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -12,10 +16,17 @@ import (
 type DB struct {
 	sync.RWMutex
 	encoder *json.Encoder
+	// path and file are the log on disk, empty and nil when the log can't be rewritten
+	path string
+	file *os.File
 	// maps channelID to the hour (utc) to send the APOD message
 	schedule map[string]int
 	// maps channelID to the date of the last APOD sent
 	last map[string]string
+	// channels whose scheduled APOD includes the ones from one and ten years ago
+	history map[string]bool
+	// maps userID to their birthday subscription
+	birthdays map[string]Birthday
 }
 
 // EventType enum
@@ -28,6 +39,10 @@ const (
 	EventTypeRemove
 	// EventTypeSent is a sent event (APOD sent)
 	EventTypeSent
+	// EventTypeBirthday is a birthday event (/birthday set)
+	EventTypeBirthday
+	// EventTypeForget is a forget event (/birthday delete)
+	EventTypeForget
 )
 
 func (e EventType) String() string {
@@ -38,6 +53,10 @@ func (e EventType) String() string {
 		return "remove"
 	case EventTypeSent:
 		return "sent"
+	case EventTypeBirthday:
+		return "birthday"
+	case EventTypeForget:
+		return "forget"
 	}
 
 	return ""
@@ -62,6 +81,10 @@ func (e *EventType) UnmarshalJSON(b []byte) error {
 		*e = EventTypeRemove
 	case "sent":
 		*e = EventTypeSent
+	case "birthday":
+		*e = EventTypeBirthday
+	case "forget":
+		*e = EventTypeForget
 	default:
 		return errors.New("invalid event type")
 	}
@@ -81,6 +104,10 @@ type Event struct {
 	Remove *RemoveEvent `json:"remove,omitempty"`
 	// Sent is the sent event (APOD sent)
 	Sent *SentEvent `json:"sent,omitempty"`
+	// Birthday is the birthday event (/birthday set)
+	Birthday *BirthdayEvent `json:"birthday,omitempty"`
+	// Forget is the forget event (/birthday delete)
+	Forget *ForgetEvent `json:"forget,omitempty"`
 }
 
 // SetEvent adds a channel to the schedule
@@ -89,6 +116,8 @@ type SetEvent struct {
 	ChannelID string `json:"channel_id"`
 	// Hour is the hour (utc) to send the APOD message
 	Hour int `json:"hour"`
+	// History adds the APODs from one and ten years ago to the message
+	History bool `json:"history,omitempty"`
 }
 
 // RemoveEvent removes a channel from the schedule
@@ -105,12 +134,35 @@ type SentEvent struct {
 	Date string `json:"date"`
 }
 
+// Birthday is a user's birthday subscription
+type Birthday struct {
+	// Date is the user's birth date (yyyy-mm-dd)
+	Date string `json:"date"`
+	// ChannelID is the channel to post in, empty to send a direct message
+	ChannelID string `json:"channel_id,omitempty"`
+}
+
+// BirthdayEvent subscribes a user to birthday APODs
+type BirthdayEvent struct {
+	// UserID is the discord user ID
+	UserID string `json:"user_id"`
+	Birthday
+}
+
+// ForgetEvent removes everything stored about a user
+type ForgetEvent struct {
+	// UserID is the discord user ID
+	UserID string `json:"user_id"`
+}
+
 // NewDB creates a new DB
 func NewDB(r io.Reader, w io.Writer) (*DB, error) {
 	db := &DB{
-		encoder:  json.NewEncoder(w),
-		schedule: make(map[string]int),
-		last:     make(map[string]string),
+		encoder:   json.NewEncoder(w),
+		schedule:  make(map[string]int),
+		last:      make(map[string]string),
+		history:   make(map[string]bool),
+		birthdays: make(map[string]Birthday),
 	}
 	if err := db.load(r); err != nil {
 		return nil, err
@@ -118,6 +170,38 @@ func NewDB(r io.Reader, w io.Writer) (*DB, error) {
 	return db, nil
 }
 
+// OpenDB loads the database stored at path, creating it if needed. The log is
+// copied through a new file on the way so that a database that couldn't be
+// compacted later fails now
+func OpenDB(path string) (*DB, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	db, err := NewDB(bytes.NewReader(data), io.Discard)
+	if err != nil {
+		return nil, err
+	}
+
+	db.path = path
+	if err := db.replace(data); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Close closes the log
+func (db *DB) Close() error {
+	db.Lock()
+	defer db.Unlock()
+
+	if db.file == nil {
+		return nil
+	}
+	return db.file.Close()
+}
+
 func (db *DB) load(r io.Reader) error {
 	dec := json.NewDecoder(r)
 	for {
@@ -136,6 +220,10 @@ func (db *DB) load(r io.Reader) error {
 			db.remove(event.Remove)
 		case EventTypeSent:
 			db.sent(event.Sent)
+		case EventTypeBirthday:
+			db.birthday(event.Birthday)
+		case EventTypeForget:
+			db.forget(event.Forget)
 		}
 	}
 
@@ -144,18 +232,32 @@ func (db *DB) load(r io.Reader) error {
 
 func (db *DB) set(event *SetEvent) {
 	db.schedule[event.ChannelID] = event.Hour
+	if event.History {
+		db.history[event.ChannelID] = true
+	} else {
+		delete(db.history, event.ChannelID)
+	}
 }
 
 func (db *DB) remove(event *RemoveEvent) {
 	delete(db.schedule, event.ChannelID)
+	delete(db.history, event.ChannelID)
 }
 
 func (db *DB) sent(event *SentEvent) {
 	db.last[event.ChannelID] = event.Date
 }
 
+func (db *DB) birthday(event *BirthdayEvent) {
+	db.birthdays[event.UserID] = event.Birthday
+}
+
+func (db *DB) forget(event *ForgetEvent) {
+	delete(db.birthdays, event.UserID)
+}
+
 // Set adds a channel to the schedule
-func (db *DB) Set(channelID string, hour int) {
+func (db *DB) Set(channelID string, hour int, history bool) {
 	db.Lock()
 	event := &Event{
 		Time: time.Now(),
@@ -163,6 +265,7 @@ func (db *DB) Set(channelID string, hour int) {
 		Set: &SetEvent{
 			ChannelID: channelID,
 			Hour:      hour,
+			History:   history,
 		},
 	}
 	db.set(event.Set)
@@ -244,3 +347,128 @@ func (db *DB) GetLast(channelID string) (string, bool) {
 	db.RUnlock()
 	return date, ok
 }
+
+// History checks if a channel's scheduled APOD includes the ones from one and ten years ago
+func (db *DB) History(channelID string) bool {
+	db.RLock()
+	history := db.history[channelID]
+	db.RUnlock()
+	return history
+}
+
+// SetBirthday subscribes a user to the APODs from their birthday
+func (db *DB) SetBirthday(userID string, birthday Birthday) {
+	db.Lock()
+	event := &Event{
+		Time: time.Now(),
+		Type: EventTypeBirthday,
+		Birthday: &BirthdayEvent{
+			UserID:   userID,
+			Birthday: birthday,
+		},
+	}
+	db.birthday(event.Birthday)
+	db.encoder.Encode(event)
+	db.Unlock()
+}
+
+// GetBirthday returns a user's birthday subscription
+func (db *DB) GetBirthday(userID string) (Birthday, bool) {
+	db.RLock()
+	birthday, ok := db.birthdays[userID]
+	db.RUnlock()
+	return birthday, ok
+}
+
+// ViewBirthdays iterates over all birthday subscriptions
+func (db *DB) ViewBirthdays(f func(string, Birthday)) {
+	db.RLock()
+	for userID, birthday := range db.birthdays {
+		f(userID, birthday)
+	}
+	db.RUnlock()
+}
+
+// Forget unsubscribes a user and deletes their data. The log keeps every
+// event, so it is rewritten without the user's earlier ones
+func (db *DB) Forget(userID string) error {
+	db.Lock()
+	defer db.Unlock()
+
+	event := &Event{
+		Time: time.Now(),
+		Type: EventTypeForget,
+		Forget: &ForgetEvent{
+			UserID: userID,
+		},
+	}
+	db.forget(event.Forget)
+	db.encoder.Encode(event)
+	return db.compact()
+}
+
+// compact rewrites the log as the events needed to rebuild the current state.
+// It must be called with the lock held
+func (db *DB) compact() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	now := time.Now()
+	for channelID, hour := range db.schedule {
+		enc.Encode(&Event{Time: now, Type: EventTypeSet, Set: &SetEvent{ChannelID: channelID, Hour: hour, History: db.history[channelID]}})
+	}
+	for channelID, date := range db.last {
+		enc.Encode(&Event{Time: now, Type: EventTypeSent, Sent: &SentEvent{ChannelID: channelID, Date: date}})
+	}
+	for userID, birthday := range db.birthdays {
+		enc.Encode(&Event{Time: now, Type: EventTypeBirthday, Birthday: &BirthdayEvent{UserID: userID, Birthday: birthday}})
+	}
+
+	return db.replace(buf.Bytes())
+}
+
+// replace swaps the log for one holding data. data is written to a temporary
+// file that is synced and renamed over the log, so a crash leaves either the
+// old log or the new one, never a mix. It must be called with the lock held
+// or before the DB is shared
+func (db *DB) replace(data []byte) error {
+	if db.path == "" {
+		return errors.New("database has no file to rewrite")
+	}
+
+	dir := filepath.Dir(db.path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(db.path)+"-*")
+	if err != nil {
+		return err
+	}
+
+	// Nothing is left behind if anything fails before the rename
+	err = tmp.Chmod(0644)
+	if err == nil {
+		_, err = tmp.Write(data)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), db.path)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// Make the rename itself durable, not every platform can sync a directory
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	// The temporary file is the log now, new events are appended to it
+	if db.file != nil {
+		db.file.Close()
+	}
+	db.file = tmp
+	db.encoder = json.NewEncoder(tmp)
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Alextopher/apod-bot/internal/apod"
+	"github.com/bwmarrin/discordgo"
+)
+
+// errorMessages maps each kind of APOD error to what users are told, by language.
+//
+// Messages for dates take the date as their only argument
+var errorMessages = map[apod.ErrorKind]map[string]string{
+	apod.KindUnknown: {
+		"en": "Sorry, something went wrong getting the APOD. Please try again later.",
+		"es": "Lo siento, algo salió mal al obtener la APOD. Inténtalo de nuevo más tarde.",
+		"fr": "Désolé, une erreur s'est produite lors de la récupération de l'APOD. Veuillez réessayer plus tard.",
+		"de": "Entschuldigung, beim Abrufen des APOD ist etwas schiefgelaufen. Bitte versuche es später erneut.",
+		"pt": "Desculpe, algo deu errado ao buscar o APOD. Tente novamente mais tarde.",
+	},
+	apod.KindTimeout: {
+		"en": "Sorry, NASA took too long to respond. Please try again later.",
+		"es": "Lo siento, la NASA tardó demasiado en responder. Inténtalo de nuevo más tarde.",
+		"fr": "Désolé, la NASA a mis trop de temps à répondre. Veuillez réessayer plus tard.",
+		"de": "Entschuldigung, die NASA hat zu lange für eine Antwort gebraucht. Bitte versuche es später erneut.",
+		"pt": "Desculpe, a NASA demorou muito para responder. Tente novamente mais tarde.",
+	},
+	apod.KindRateLimited: {
+		"en": "I've used up my NASA API requests for now. Please try again in a little while.",
+		"es": "He agotado mis solicitudes a la API de la NASA por ahora. Inténtalo de nuevo en un rato.",
+		"fr": "J'ai épuisé mes requêtes à l'API de la NASA pour le moment. Veuillez réessayer dans un moment.",
+		"de": "Ich habe meine NASA-API-Anfragen vorerst aufgebraucht. Bitte versuche es in Kürze erneut.",
+		"pt": "Esgotei minhas solicitações à API da NASA por enquanto. Tente novamente daqui a pouco.",
+	},
+	apod.KindUnauthorized: {
+		"en": "NASA rejected my API key. Please let the bot's owner know.",
+		"es": "La NASA rechazó mi clave de API. Por favor, avisa al dueño del bot.",
+		"fr": "La NASA a refusé ma clé d'API. Veuillez prévenir le propriétaire du bot.",
+		"de": "Die NASA hat meinen API-Schlüssel abgelehnt. Bitte gib dem Besitzer des Bots Bescheid.",
+		"pt": "A NASA rejeitou minha chave de API. Por favor, avise o dono do bot.",
+	},
+	apod.KindUnavailable: {
+		"en": "NASA's APOD service is having trouble right now. Please try again later.",
+		"es": "El servicio APOD de la NASA tiene problemas en este momento. Inténtalo de nuevo más tarde.",
+		"fr": "Le service APOD de la NASA rencontre des difficultés. Veuillez réessayer plus tard.",
+		"de": "Der APOD-Dienst der NASA hat gerade Probleme. Bitte versuche es später erneut.",
+		"pt": "O serviço APOD da NASA está com problemas no momento. Tente novamente mais tarde.",
+	},
+	apod.KindDateInvalid: {
+		"en": "%q is not a date with an APOD. Try yyyy-mm-dd, \"July 1 2021\" or an apod.nasa.gov link, on or after 1995-06-16.",
+		"es": "%q no es una fecha con APOD. Prueba aaaa-mm-dd, \"July 1 2021\" o un enlace de apod.nasa.gov, a partir del 1995-06-16.",
+		"fr": "%q n'est pas une date avec une APOD. Essayez aaaa-mm-jj, \"July 1 2021\" ou un lien apod.nasa.gov, à partir du 1995-06-16.",
+		"de": "%q ist kein Datum mit einem APOD. Versuche jjjj-mm-tt, \"July 1 2021\" oder einen apod.nasa.gov-Link, ab dem 1995-06-16.",
+		"pt": "%q não é uma data com APOD. Tente aaaa-mm-dd, \"July 1 2021\" ou um link do apod.nasa.gov, a partir de 1995-06-16.",
+	},
+	apod.KindDateNotFound: {
+		"en": "There is no APOD for %s.",
+		"es": "No hay APOD para el %s.",
+		"fr": "Il n'y a pas d'APOD pour le %s.",
+		"de": "Für den %s gibt es kein APOD.",
+		"pt": "Não há APOD para %s.",
+	},
+	apod.KindNotPublished: {
+		"en": "Today's APOD hasn't been published yet. Please try again soon.",
+		"es": "La APOD de hoy aún no se ha publicado. Inténtalo de nuevo pronto.",
+		"fr": "L'APOD du jour n'a pas encore été publiée. Veuillez réessayer bientôt.",
+		"de": "Das heutige APOD wurde noch nicht veröffentlicht. Bitte versuche es bald erneut.",
+		"pt": "O APOD de hoje ainda não foi publicado. Tente novamente em breve.",
+	},
+	apod.KindNoMatch: {
+		"en": "I couldn't find an APOD matching those filters.",
+		"es": "No encontré ninguna APOD que coincida con esos filtros.",
+		"fr": "Je n'ai trouvé aucune APOD correspondant à ces filtres.",
+		"de": "Ich konnte kein APOD finden, das zu diesen Filtern passt.",
+		"pt": "Não encontrei nenhum APOD que corresponda a esses filtros.",
+	},
+	apod.KindArchiveUnavailable: {
+		"en": "The APOD archive on apod.nasa.gov is having trouble right now. Please try again later.",
+		"es": "El archivo APOD de apod.nasa.gov tiene problemas en este momento. Inténtalo de nuevo más tarde.",
+		"fr": "L'archive APOD sur apod.nasa.gov rencontre des difficultés. Veuillez réessayer plus tard.",
+		"de": "Das APOD-Archiv auf apod.nasa.gov hat gerade Probleme. Bitte versuche es später erneut.",
+		"pt": "O arquivo APOD em apod.nasa.gov está com problemas no momento. Tente novamente mais tarde.",
+	},
+}
+
+// suggestionMessages offer the date suggested by a DateError, by language
+var suggestionMessages = map[string]string{
+	"en": "Did you mean %s?",
+	"es": "¿Quisiste decir %s?",
+	"fr": "Vouliez-vous dire %s ?",
+	"de": "Meintest du %s?",
+	"pt": "Você quis dizer %s?",
+}
+
+// errorMessage turns an error from the APOD client into a message for a user
+// with the given Discord locale, falling back to English
+func errorMessage(locale discordgo.Locale, err error) string {
+	kind := apod.KindOf(err)
+	messages, ok := errorMessages[kind]
+	if !ok {
+		messages = errorMessages[apod.KindUnknown]
+	}
+
+	language, _, _ := strings.Cut(string(locale), "-")
+	message, ok := messages[language]
+	if !ok {
+		message = messages["en"]
+	}
+
+	if kind == apod.KindDateInvalid || kind == apod.KindDateNotFound {
+		var dateErr *apod.DateError
+		if !errors.As(err, &dateErr) {
+			return errorMessage(locale, nil)
+		}
+		message = fmt.Sprintf(message, dateErr.Date)
+		if dateErr.Suggestion != "" {
+			suggestion, ok := suggestionMessages[language]
+			if !ok {
+				suggestion = suggestionMessages["en"]
+			}
+			message += " " + fmt.Sprintf(suggestion, dateErr.Suggestion)
+		}
+	}
+	return message
+}
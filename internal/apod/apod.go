@@ -1,11 +1,14 @@
 package apod
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log"
-	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Alextopher/apod-bot/internal/cache"
@@ -15,24 +18,93 @@ import (
 //
 // It maintains a cache for APOD responses and an image cache for images.
 type APOD struct {
-	key        string
+	baseURL    string
+	client     *http.Client
+	timeout    time.Duration
 	cache      cache.Cache[*Response]
 	imageCache cache.Cache[*ImageWrapper]
-	// To avoid issues with timezones we keep track of the most recent APOD response date
-	// and we only update that date at most once per hour
+	// missing records dates that have no APOD, see isKnownMissing
+	missing cache.Cache[*MissingDate]
+	// index is a search index over every cached response, tags stores the
+	// topics each one was classified under
+	index *Index
+	tags  cache.Cache[*Tags]
+	// credits indexes the contributors of every cached response
+	credits *Credits
+
+	// maxImageSize limits image downloads, progress is told how they're going
+	maxImageSize int64
+	progress     func(DownloadProgress)
+
+	// fallback is used when the JSON API fails, nil to disable
+	fallback    *Scraper
+	hasFallback bool
+
+	// keys rotates requests across API keys and tracks the budget NASA
+	// reports for each, Fill holds back once less than fillReserve of it remains
+	keys        keyPool
+	fillReserve float64
+
+	// responses, images and variants merge concurrent requests for the same date
+	responses flightGroup[*Response]
+	images    flightGroup[*ImageWrapper]
+	variants  flightGroup[*ImageWrapper]
+
+	// The most recent APOD response is kept in memory until NASA's publishing
+	// day rolls over, see isFresh
+	now        func() time.Time
+	mu         sync.RWMutex
 	lastUpdate time.Time
 	current    *Response
 }
 
 // NewClient creates a new APOD client
-func NewClient(key string, cache cache.Cache[*Response], imageCache cache.Cache[*ImageWrapper]) *APOD {
-	return &APOD{
-		key:        key,
-		cache:      cache,
-		imageCache: imageCache,
-		lastUpdate: time.Unix(0, 0), // the past
-		current:    nil,
+//
+// Additional API keys can be put into rotation with WithKeys
+func NewClient(key string, apodCache cache.Cache[*Response], imageCache cache.Cache[*ImageWrapper], opts ...Option) *APOD {
+	a := &APOD{
+		keys:         keyPool{cooldown: DefaultKeyCooldown},
+		baseURL:      DefaultBaseURL,
+		client:       http.DefaultClient,
+		timeout:      DefaultRequestTimeout,
+		maxImageSize: DefaultMaxImageSize,
+		fillReserve:  DefaultFillReserve,
+		imageCache:   imageCache,
+		missing:      cache.NewEmptyCache[*MissingDate](),
+		tags:         cache.NewEmptyCache[*Tags](),
+		now:          time.Now,
+		lastUpdate:   time.Unix(0, 0), // the past
+		current:      nil,
+	}
+	a.keys.add(key)
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if !a.hasFallback {
+		a.fallback, _ = NewScraper(DefaultArchiveURL, a.client)
+	}
+
+	// Index and tag what's cached already, and everything added from now on
+	a.index = NewIndex()
+	a.credits = NewCredits()
+	if lister, ok := apodCache.(cache.Lister); ok {
+		for _, date := range lister.Dates() {
+			if response, ok := apodCache.Get(date); ok {
+				a.index.Add(response, a.storedTags(response))
+				a.credits.Add(response)
+			}
+		}
 	}
+	a.cache = &indexedCache{Cache: apodCache, onAdd: a.indexResponse}
+	return a
+}
+
+// endpoint builds an API request URL from the given query parameters
+func (a *APOD) endpoint(key string, params url.Values) string {
+	params.Set("thumbs", "true")
+	params.Set("api_key", key)
+	return a.baseURL + "?" + params.Encode()
 }
 
 // IsValidDate checks if a date is formatted correctly and occurs after the first published APOD
@@ -43,29 +115,49 @@ func IsValidDate(date string) bool {
 		return false
 	}
 
-	start := time.Date(1995, 6, 16, 0, 0, 0, 0, time.UTC)
-	return d.After(start)
+	return !d.Before(FirstDate)
 }
 
-var (
-	// ErrorDateNotFound is returned when given date is not found on the NASA API
-	ErrorDateNotFound = fmt.Errorf("date not found in NASA API")
-	// ErrorDateInvalid is returned when given date is not in the correct format
-	ErrorDateInvalid = fmt.Errorf("date is not in the correct format, use yyyy-mm-dd")
-)
+// get makes a GET request to the API with the next key in rotation, cutting it
+// off after the client's per-request timeout.
+//
+// The returned cancel function must be called once the response body has been read
+func (a *APOD) get(ctx context.Context, params url.Values) (*http.Response, context.CancelFunc, error) {
+	key := a.keys.pick()
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.endpoint(key.key, params), nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+
+	a.keys.report(key, resp)
+	return resp, cancel, nil
+}
 
 // singleRequest makes an HTTP request to the NASA API and expects a single APOD response
-func (a *APOD) singleRequest(req string) (*Response, error) {
-	resp, err := http.Get(req)
+func (a *APOD) singleRequest(ctx context.Context, params url.Values) (*Response, error) {
+	resp, cancel, err := a.get(ctx, params)
 	if err != nil {
 		return nil, err
 	}
+	defer cancel()
+	defer resp.Body.Close()
 
 	// Check for non-200 status code
 	if resp.StatusCode == http.StatusNotFound {
 		return nil, ErrorDateNotFound
 	} else if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("NASA API Failure: %s", resp.Status)
+		apiErr := newAPIError(resp)
+		apiErr.Date = params.Get("date")
+		return nil, apiErr
 	}
 
 	// Decode the JSON response
@@ -79,19 +171,20 @@ func (a *APOD) singleRequest(req string) (*Response, error) {
 }
 
 // rangeRequest returns all APODs between two dates (inclusive)
-func (a *APOD) rangeRequest(start, end string) ([]*Response, error) {
+func (a *APOD) rangeRequest(ctx context.Context, start, end string) ([]*Response, error) {
 	log.Println("Getting APODs from", start, "to", end)
 
 	// Get the JSON response from the API
-	req := fmt.Sprintf("https://api.nasa.gov/planetary/apod?thumbs=true&start_date=%s&end_date=%s&api_key=%s", start, end, a.key)
-	resp, err := http.Get(req)
+	resp, cancel, err := a.get(ctx, url.Values{"start_date": {start}, "end_date": {end}})
 	if err != nil {
 		return nil, err
 	}
+	defer cancel()
+	defer resp.Body.Close()
 
 	// Check for non-200 status code
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("NASA API Failure: %s", resp.Status)
+		return nil, newAPIError(resp)
 	}
 
 	// Decode the JSON response
@@ -106,132 +199,178 @@ func (a *APOD) rangeRequest(start, end string) ([]*Response, error) {
 	return responses, err
 }
 
-// Get the APOD response for a specific date
-//
-// Uses the cache if the response is already stored
-func (a *APOD) Get(date string) (response *Response, err error) {
-	// Check if the date is valid and if not, send an error message.
-	if !IsValidDate(date) {
-		return nil, ErrorDateInvalid
-	}
+// countRequest gets count random APODs in a single request and adds them to the cache
+func (a *APOD) countRequest(ctx context.Context, count int) ([]*Response, error) {
+	log.Println("Getting", count, "random APODs")
 
-	// If the cache has the response, return that
-	if resp, ok := a.cache.Get(date); ok {
-		return resp, err
+	resp, cancel, err := a.get(ctx, url.Values{"count": {strconv.Itoa(count)}})
+	if err != nil {
+		return nil, err
 	}
+	defer cancel()
+	defer resp.Body.Close()
 
-	req := fmt.Sprintf("https://api.nasa.gov/planetary/apod?thumbs=true&date=%s&api_key=%s", date, a.key)
-	response, err = a.singleRequest(req)
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
 
+	var responses []*Response
+	err = json.NewDecoder(resp.Body).Decode(&responses)
 	if err != nil {
-		return response, err
+		return nil, err
 	}
 
-	// Add the response to the cache
-	a.cache.Add(response.Date, response)
-	return response, nil
+	err = cache.AddAll(a.cache, responses)
+	return responses, err
 }
 
-// Today gets the APOD response for today
-func (a *APOD) Today() (response *Response, err error) {
-	if a.current != nil && time.Since(a.lastUpdate) < time.Hour {
-		return a.current, nil
+// shouldFallback checks if a failed API request should be retried against the archive
+func (a *APOD) shouldFallback(ctx context.Context, err error) bool {
+	if err == nil || a.fallback == nil || ctx.Err() != nil {
+		return false
 	}
+	return !errors.Is(err, ErrorDateInvalid) && !errors.Is(err, ErrorDateNotFound)
+}
+
+// fallbackRequest runs request against the archive after the API failed with
+// apiErr, returning apiErr if the archive fails too. The request is cut off
+// after the client's per-request timeout like any API call
+func (a *APOD) fallbackRequest(ctx context.Context, apiErr error, request func(context.Context) (*Response, error)) (*Response, error) {
+	log.Println("NASA API failed, falling back to apod.nasa.gov:", apiErr)
 
-	req := fmt.Sprintf("https://api.nasa.gov/planetary/apod?thumbs=true&api_key=%s", a.key)
-	response, err = a.singleRequest(req)
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
 
+	response, err := request(ctx)
 	if err != nil {
-		return response, err
+		log.Println("apod.nasa.gov failed too:", err)
+		return nil, apiErr
 	}
-
-	// Add the response to the cache
-	a.current = response
-	a.lastUpdate = time.Now()
-	a.cache.Add(response.Date, response)
 	return response, nil
 }
 
-// Fill runs in the background and fills the cache with _ALL_ APOD responses from the NASA API
-func (a *APOD) Fill() {
-	// Must be after 1995-06-16 (first APOD)
-	start := time.Date(1995, 6, 16, 0, 0, 0, 0, time.UTC)
+// Get the APOD response for a specific date
+//
+// Uses the cache if the response is already stored
+func (a *APOD) Get(date string) (*Response, error) {
+	return a.GetContext(context.Background(), date)
+}
 
-	// Get today's date from APOD's perspective
-	todayResp, err := a.Today()
-	if err != nil {
-		log.Println("Error getting today's APOD, so we can't start filling the cache:", err)
-		return
+// GetContext is like Get but stops waiting on the NASA API when ctx is done
+func (a *APOD) GetContext(ctx context.Context, date string) (response *Response, err error) {
+	// Check if the date is valid and if not, send an error message.
+	if !IsValidDate(date) {
+		return nil, &DateError{Date: date, Err: ErrorDateInvalid}
 	}
-	today, _ := time.Parse("2006-01-02", todayResp.Date)
 
-	// "Iterator" over every day from start to today
-	for d := start; d.Before(today); d = d.AddDate(0, 0, 1) {
-		// Skip days that are already cached
-		if a.cache.Has(d.Format("2006-01-02")) {
-			continue
-		}
+	// If the cache has the response, return that
+	if resp, ok := a.cache.Get(date); ok {
+		return resp, err
+	}
 
-		// We found a gap in the cache, search to find the end of the gap
-		end := d.AddDate(0, 0, 1)
-		for !a.cache.Has(end.Format("2006-01-02")) && end.Sub(d) < 30*24*time.Hour && end.Before(today) {
-			end = end.AddDate(0, 0, 1)
-		}
+	// Don't ask again for dates that recently had no APOD
+	if a.isKnownMissing(date) {
+		return nil, &DateError{Date: date, Err: ErrorDateNotFound, Suggestion: a.nearestCached(date)}
+	}
 
-		// Get the APODs for the gap
-		_, err := a.rangeRequest(d.Format("2006-01-02"), end.Format("2006-01-02"))
+	// Concurrent requests for the same date share a single API call
+	return a.responses.do(ctx, date, func(ctx context.Context) (*Response, error) {
+		response, err := a.singleRequest(ctx, url.Values{"date": {date}})
+		if a.shouldFallback(ctx, err) {
+			response, err = a.fallbackRequest(ctx, err, func(ctx context.Context) (*Response, error) {
+				return a.fallback.Get(ctx, date)
+			})
+		}
+		if errors.Is(err, ErrorDateNotFound) {
+			a.markMissing(date)
+			return nil, &DateError{Date: date, Err: ErrorDateNotFound, Suggestion: a.nearestCached(date)}
+		}
 		if err != nil {
-			log.Println("Error getting APODs:", err)
-			continue
+			return response, err
+		}
+
+		// Add the response to the cache, unless it came from the archive and
+		// the API should be asked again later
+		if response.Service != scrapedService {
+			a.cache.Add(response.Date, response)
 		}
+		return response, nil
+	})
+}
+
+// Cached returns the cached APOD for a date without asking NASA
+func (a *APOD) Cached(date string) (*Response, bool) {
+	return a.cache.Get(date)
+}
 
-		d = end
-		time.Sleep(5 * time.Second)
+// Today gets the APOD response for today
+func (a *APOD) Today() (*Response, error) {
+	return a.TodayContext(context.Background())
+}
+
+// TodayContext is like Today but stops waiting on the NASA API when ctx is done
+func (a *APOD) TodayContext(ctx context.Context) (*Response, error) {
+	a.mu.RLock()
+	current, lastUpdate := a.current, a.lastUpdate
+	a.mu.RUnlock()
+
+	if a.isFresh(current, lastUpdate) {
+		return current, nil
 	}
 
-	// Go back through, getting individual APODs that are still missing
-	for d := start; d.Before(today); d = d.AddDate(0, 0, 1) {
-		// Skip days that are already cached
-		if a.cache.Has(d.Format("2006-01-02")) {
-			continue
-		}
+	return a.refreshToday(ctx)
+}
 
-		// Get the APOD for the day
-		_, err := a.Get(d.Format("2006-01-02"))
+// refreshToday asks NASA for the current APOD, skipping the in memory copy
+func (a *APOD) refreshToday(ctx context.Context) (*Response, error) {
+	// Concurrent requests for today share a single API call. Dates are never
+	// empty, so the key can't collide with Get
+	return a.responses.do(ctx, "", func(ctx context.Context) (*Response, error) {
+		response, err := a.singleRequest(ctx, url.Values{})
+		if a.shouldFallback(ctx, err) {
+			response, err = a.fallbackRequest(ctx, err, func(ctx context.Context) (*Response, error) {
+				return a.fallback.Today(ctx)
+			})
+		}
 		if err != nil {
-			log.Println("Failed to get APOD for", d.Format("2006-01-02"), "on retry. This is probably fine:", err)
-			continue
+			return response, err
 		}
-		time.Sleep(1 * time.Second)
-	}
 
-	log.Println("Finished filling cache!!")
-}
+		// Add the response to the cache
+		a.mu.Lock()
+		a.current = response
+		a.lastUpdate = a.now()
+		a.mu.Unlock()
 
-// Random gets a random APOD from the NASA API
-func (a *APOD) Random() (*Response, error) {
-	// Must be after 1995-06-16 (first APOD) and before today
-	start := time.Date(1995, 6, 16, 0, 0, 0, 0, time.UTC)
-	current, err := a.Today()
-	if err != nil {
-		return nil, err
-	}
-	end, _ := time.Parse("2006-01-02", current.Date)
+		if response.Service != scrapedService {
+			a.cache.Add(response.Date, response)
+		}
+		return response, nil
+	})
+}
 
-	// Get a random date between start and end
-	diff := end.Sub(start)
-	random := time.Duration(rand.Int63n(int64(diff)))
-	date := start.Add(random).Format("2006-01-02")
+// Fill runs in the background and fills the cache with _ALL_ APOD responses from the NASA API
+func (a *APOD) Fill() {
+	a.FillContext(context.Background())
+}
 
-	// Get the APOD for the random date (if the )
-	return a.Get(date)
+// FillContext is like Fill but stops early when ctx is done
+//
+// Use NewBackfill directly to follow progress or download images
+func (a *APOD) FillContext(ctx context.Context) {
+	NewBackfill(a, DefaultBackfillOptions).Run(ctx)
 }
 
 // GetImage returns the image for a specific day
 func (a *APOD) GetImage(day string) (*ImageWrapper, error) {
+	return a.GetImageContext(context.Background(), day)
+}
+
+// GetImageContext is like GetImage but stops waiting on downloads when ctx is done
+func (a *APOD) GetImageContext(ctx context.Context, day string) (*ImageWrapper, error) {
 	// Check if the date is valid and if not, send an error message.
 	if !IsValidDate(day) {
-		return nil, ErrorDateInvalid
+		return nil, &DateError{Date: day, Err: ErrorDateInvalid}
 	}
 
 	// If the image cache has the image, return that
@@ -240,33 +379,24 @@ func (a *APOD) GetImage(day string) (*ImageWrapper, error) {
 	}
 
 	// Otherwise, we need to get the APOD response
-	response, err := a.Get(day)
+	response, err := a.GetContext(ctx, day)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get the image from the response
-	image, err := response.DownloadRawImage()
-	if err != nil {
-		return nil, err
-	}
+	// Concurrent requests for the same image share a single download
+	return a.images.do(ctx, day, func(ctx context.Context) (*ImageWrapper, error) {
+		ctx, cancel := context.WithTimeout(ctx, a.timeout)
+		defer cancel()
 
-	// Add the full size image to the cache
-	a.imageCache.Add(day, image)
-	return image, nil
-}
-
-// Retry is a helper function that reruns a function until it succeeds, at most 5 times
-func Retry[T any](f func() (T, error)) (res T, err error) {
-	// exponential backoff
-	backOff := 64 * time.Millisecond
-
-	for i := 0; i < 5; i++ {
-		res, err = f()
-		if err == nil {
-			return res, nil
+		// Get the image from the response
+		image, err := response.downloadRawImage(ctx, downloader{client: a.client, limit: a.maxImageSize, progress: a.progress})
+		if err != nil {
+			return nil, err
 		}
-		backOff *= 2
-	}
-	return res, err
+
+		// Add the full size image to the cache
+		a.imageCache.Add(day, image)
+		return image, nil
+	})
 }
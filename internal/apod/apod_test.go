@@ -2,52 +2,124 @@ package apod
 
 // Verify that api commands are working as expected
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/Alextopher/apod-bot/internal/cache"
-	"github.com/joho/godotenv"
 )
 
-func createAPITestAPOD() *APOD {
-	key, ok := os.LookupEnv("APOD_TOKEN")
-	if !ok {
-		godotenv.Load()
-		key, ok = os.LookupEnv("APOD_TOKEN")
-		if !ok {
-			key = "DEMO_KEY"
+// today is the date the stand-in server reports as the most recent APOD
+const today = "2021-07-01"
+
+// newTestServer creates a stand-in for the NASA API that serves responses
+// from testdata/<date>.json
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	load := func(date string) (*Response, bool) {
+		data, err := os.ReadFile(filepath.Join("testdata", date+".json"))
+		if err != nil {
+			return nil, false
 		}
+		var resp Response
+		if err := json.Unmarshal(data, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return &resp, true
 	}
 
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("api_key") == "" {
+			http.Error(w, "missing api_key", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		w.Header().Set("X-RateLimit-Remaining", "999")
+
+		// Range requests
+		if start, end := query.Get("start_date"), query.Get("end_date"); start != "" && end != "" {
+			responses := []*Response{}
+			matches, _ := filepath.Glob(filepath.Join("testdata", "*.json"))
+			for _, match := range matches {
+				date := filepath.Base(match[:len(match)-len(".json")])
+				if date < start || date > end {
+					continue
+				}
+				if resp, ok := load(date); ok {
+					responses = append(responses, resp)
+				}
+			}
+			json.NewEncoder(w).Encode(responses)
+			return
+		}
+
+		date := query.Get("date")
+		if date == "" {
+			date = today
+		}
+
+		resp, ok := load(date)
+		if !ok {
+			http.Error(w, "No data available for date", http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newTestClient creates a client for the API at baseURL that never touches
+// apod.nasa.gov and caches nothing
+func newTestClient(baseURL string, opts ...Option) *APOD {
 	// Empty cache (reads and writes nothing)
-	imageCache := NewImageCache("images")
 	apodCache := cache.NewEmptyCache[*Response]()
-
 	// Empty image cache (reads and writes nothing)
-	return &APOD{
-		key:        key,
-		cache:      apodCache,
-		imageCache: imageCache,
-	}
+	imageCache := cache.NewEmptyCache[*ImageWrapper]()
+
+	opts = append([]Option{WithBaseURL(baseURL), WithFallback(nil)}, opts...)
+	return NewClient("DEMO_KEY", apodCache, imageCache, opts...)
+}
+
+func createAPITestAPOD(t *testing.T) *APOD {
+	server := newTestServer(t)
+	return newTestClient(server.URL, WithHTTPClient(server.Client()))
 }
 
 // Verify that the default APOD request works
 func TestToday(t *testing.T) {
-	apod := createAPITestAPOD()
+	apod := createAPITestAPOD(t)
 
-	_, err := apod.Today()
+	resp, err := apod.Today()
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
+	}
+
+	if resp.Date != today {
+		t.Error("Incorrect date")
 	}
 }
 
 // Verify a particular date
 func TestGet(t *testing.T) {
-	apod := createAPITestAPOD()
+	apod := createAPITestAPOD(t)
 
 	resp, err := apod.Get("2021-07-01")
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
 
 	if resp.Title != "Perseverance Selfie with Ingenuity" {
@@ -82,3 +154,499 @@ func TestGet(t *testing.T) {
 		t.Error("Incorrect service")
 	}
 }
+
+// Verify that missing dates are reported as ErrorDateNotFound
+func TestGetNotFound(t *testing.T) {
+	apod := createAPITestAPOD(t)
+
+	_, err := apod.Get("2021-07-02")
+	if !errors.Is(err, ErrorDateNotFound) {
+		t.Errorf("expected ErrorDateNotFound, got %v", err)
+	}
+
+	var dateErr *DateError
+	if !errors.As(err, &dateErr) || dateErr.Date != "2021-07-02" {
+		t.Errorf("expected a DateError for 2021-07-02, got %v", err)
+	}
+}
+
+// Verify that a hung NASA API is cut off by the request timeout
+func TestGetTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	apod := newTestClient(server.URL, WithRequestTimeout(50*time.Millisecond))
+
+	_, err := apod.GetContext(context.Background(), "2021-07-01")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// Verify that the request budget is read from the response headers
+func TestRateLimit(t *testing.T) {
+	apod := createAPITestAPOD(t)
+
+	if apod.RateLimit().Known() {
+		t.Error("expected an unknown budget before any requests")
+	}
+
+	if _, err := apod.Today(); err != nil {
+		t.Fatal(err)
+	}
+
+	limit := apod.RateLimit()
+	if limit.Limit != 1000 || limit.Remaining != 999 {
+		t.Errorf("expected 999/1000, got %d/%d", limit.Remaining, limit.Limit)
+	}
+	if limit.Low(DefaultFillReserve) {
+		t.Error("expected the budget not to be low")
+	}
+	if !(RateLimit{Limit: 1000, Remaining: 100, Updated: time.Now()}).Low(DefaultFillReserve) {
+		t.Error("expected the budget to be low")
+	}
+}
+
+// Verify that a key answered with a 429 is taken out of rotation
+func TestKeyRotation(t *testing.T) {
+	var mu sync.Mutex
+	var used []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("api_key")
+		mu.Lock()
+		used = append(used, key)
+		mu.Unlock()
+		if key == "LIMITED" {
+			http.Error(w, "rate limited", http.StatusTooManyRequests)
+			return
+		}
+		json.NewEncoder(w).Encode(&Response{Date: today})
+	}))
+	defer server.Close()
+
+	apod := NewClient("LIMITED", cache.NewEmptyCache[*Response](), cache.NewEmptyCache[*ImageWrapper](),
+		WithBaseURL(server.URL),
+		WithFallback(nil),
+		WithKeys("SPARE", "LIMITED"),
+	)
+
+	for i := 0; i < 4; i++ {
+		apod.GetContext(context.Background(), today)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"LIMITED", "SPARE", "SPARE", "SPARE"}
+	for i, key := range expected {
+		if used[i] != key {
+			t.Errorf("request %d: expected key %s, got %s", i, key, used[i])
+		}
+	}
+
+	if keys := apod.Keys(); len(keys) != 2 || keys[0].BenchedUntil.IsZero() {
+		t.Errorf("expected LIMITED to be benched, got %v", keys)
+	}
+}
+
+// Verify that a rejected key is reported once when it is taken out of rotation
+func TestKeyRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("api_key") == "REVOKED_KEY" {
+			http.Error(w, "API_KEY_INVALID", http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(&Response{Date: today})
+	}))
+	defer server.Close()
+
+	var rejected []KeyStatus
+	apod := NewClient("REVOKED_KEY", cache.NewEmptyCache[*Response](), cache.NewEmptyCache[*ImageWrapper](),
+		WithBaseURL(server.URL),
+		WithFallback(nil),
+		WithKeyRejected(func(key KeyStatus) { rejected = append(rejected, key) }),
+	)
+
+	// With a single key the benched key keeps being used
+	for i := 0; i < 3; i++ {
+		if _, err := apod.GetContext(context.Background(), today); KindOf(err) != KindUnauthorized {
+			t.Errorf("expected an unauthorized error, got %v", err)
+		}
+	}
+
+	if len(rejected) != 1 || rejected[0].Key != "…_KEY" {
+		t.Errorf("expected a single rejection of …_KEY, got %v", rejected)
+	}
+}
+
+// Verify that concurrent callers share a single upstream request, run with -race
+func TestCoalescing(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		date := r.URL.Query().Get("date")
+		if date == "" {
+			date = today
+		}
+		json.NewEncoder(w).Encode(&Response{Date: date})
+	}))
+	defer server.Close()
+
+	apod := newTestClient(server.URL)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := apod.Today(); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := apod.Get("2021-06-30"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	// Give every caller a chance to join the in-flight requests
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected 2 upstream requests, got %d", n)
+	}
+
+	// Today is now served from memory
+	if _, err := apod.Today(); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected today to be cached, got %d requests", n)
+	}
+}
+
+// Verify that Today follows NASA's publishing day rather than a flat cache
+func TestTodayRollover(t *testing.T) {
+	var requests atomic.Int32
+	var latest atomic.Value
+	latest.Store("2021-07-01")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		json.NewEncoder(w).Encode(&Response{Date: latest.Load().(string)})
+	}))
+	defer server.Close()
+
+	apod := newTestClient(server.URL)
+
+	// 11 PM US Eastern on July 1st
+	now := time.Date(2021, 7, 2, 3, 0, 0, 0, time.UTC)
+	apod.now = func() time.Time { return now }
+
+	check := func(step, date string, count int32) {
+		t.Helper()
+		resp, err := apod.Today()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Date != date {
+			t.Errorf("%s: expected %s, got %s", step, date, resp.Date)
+		}
+		if n := requests.Load(); n != count {
+			t.Errorf("%s: expected %d requests, got %d", step, count, n)
+		}
+	}
+
+	check("before rollover", "2021-07-01", 1)
+	if date := apod.TodayDate(); date != "2021-07-01" {
+		t.Errorf("expected NASA's today to be 2021-07-01, got %s", date)
+	}
+
+	// 12:10 AM US Eastern, NASA has not published yet
+	now = now.Add(70 * time.Minute)
+	if date := apod.TodayDate(); date != "2021-07-02" {
+		t.Errorf("expected NASA's today to be 2021-07-02, got %s", date)
+	}
+	check("after rollover", "2021-07-01", 2)
+
+	if _, err := apod.PublishedTodayContext(context.Background()); err != ErrorNotPublished {
+		t.Errorf("expected ErrorNotPublished, got %v", err)
+	}
+
+	now = now.Add(2 * time.Minute)
+	check("between rechecks", "2021-07-01", 3)
+
+	latest.Store("2021-07-02")
+	now = now.Add(rolloverRecheck)
+	check("after publishing", "2021-07-02", 4)
+
+	now = now.Add(time.Hour)
+	check("same day", "2021-07-02", 4)
+}
+
+// Verify that dates with no APOD are remembered and rechecked later
+func TestMissingDates(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "No data available for date", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	missing, err := cache.NewAppendCache[*MissingDate](strings.NewReader(""), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apod := newTestClient(server.URL, WithMissingCache(missing))
+	now := time.Date(2021, 7, 10, 12, 0, 0, 0, time.UTC)
+	apod.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if _, err := apod.Get("2021-07-02"); !errors.Is(err, ErrorDateNotFound) {
+			t.Errorf("expected ErrorDateNotFound, got %v", err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+
+	// Recent dates are rechecked daily
+	now = now.Add(recentMissingRecheck)
+	apod.Get("2021-07-02")
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected the date to be rechecked, got %d requests", n)
+	}
+
+	// Random draws again instead of failing on the first missing date
+	requests.Store(0)
+	if _, err := apod.RandomWith(context.Background(), RandomFilter{FromYear: 2021}); !errors.Is(err, ErrorDateNotFound) {
+		t.Errorf("expected ErrorDateNotFound, got %v", err)
+	}
+	if n := requests.Load(); n < 2 || n > maxRandomDraws {
+		t.Errorf("expected Random to draw again, got %d requests", n)
+	}
+}
+
+// Verify that failed requests are classified and carry NASA's explanation
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Limit", "1000")
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "OVER_RATE_LIMIT", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	apod := newTestClient(server.URL)
+
+	_, err := apod.Get("2021-07-01")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("expected an APIError, got %v", err)
+	}
+
+	if apiErr.StatusCode != http.StatusTooManyRequests || apiErr.Date != "2021-07-01" || apiErr.RetryAfter != time.Minute {
+		t.Errorf("unexpected error %+v", apiErr)
+	}
+	if !strings.Contains(apiErr.Body, "OVER_RATE_LIMIT") {
+		t.Errorf("expected the body to be kept, got %q", apiErr.Body)
+	}
+	if apiErr.RateLimit.Limit != 1000 || apiErr.RateLimit.Remaining != 0 {
+		t.Errorf("expected the rate limit to be kept, got %v", apiErr.RateLimit)
+	}
+	if !apiErr.Retryable() || !errors.Is(err, ErrorRateLimited) || KindOf(err) != KindRateLimited {
+		t.Error("expected a retryable rate limit error")
+	}
+
+	if _, err := apod.Get("yesterday"); KindOf(err) != KindDateInvalid {
+		t.Errorf("expected KindDateInvalid, got %v", err)
+	}
+}
+
+// Verify that ranges only fetch what isn't cached and come back in order
+func TestGetRange(t *testing.T) {
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		start, end := query.Get("start_date"), query.Get("end_date")
+		mu.Lock()
+		ranges = append(ranges, start+"/"+end)
+		mu.Unlock()
+
+		// 2021-06-30 has no APOD
+		responses := []*Response{}
+		first, _ := time.Parse("2006-01-02", start)
+		last, _ := time.Parse("2006-01-02", end)
+		for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+			if date := d.Format("2006-01-02"); date != "2021-06-30" {
+				responses = append(responses, &Response{Date: date})
+			}
+		}
+		json.NewEncoder(w).Encode(responses)
+	}))
+	defer server.Close()
+
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(`{"date":"2021-06-27"}`+"\n"), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := cache.NewAppendCache[*MissingDate](strings.NewReader(""), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apod := NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](),
+		WithBaseURL(server.URL),
+		WithFallback(nil),
+		WithMissingCache(missing),
+	)
+	apod.now = func() time.Time { return time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC) }
+
+	// The end is clamped to today
+	responses, err := apod.GetRange(context.Background(), "2021-06-25", "2021-07-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dates []string
+	for _, response := range responses {
+		dates = append(dates, response.Date)
+	}
+	expected := "2021-06-25 2021-06-26 2021-06-27 2021-06-28 2021-06-29 2021-07-01"
+	if got := strings.Join(dates, " "); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if got := strings.Join(ranges, " "); got != "2021-06-25/2021-06-26 2021-06-28/2021-07-01" {
+		t.Errorf("expected only the gaps to be fetched, got %s", got)
+	}
+
+	// Everything is known now, so iterating makes no requests
+	ranges = nil
+	it := apod.IterRange(context.Background(), "2021-06-25", "2021-07-01")
+	var n int
+	for it.Next() {
+		n++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 || len(ranges) != 0 {
+		t.Errorf("expected 6 cached APODs and no requests, got %d and %v", n, ranges)
+	}
+
+	if _, err := apod.GetRange(context.Background(), "2021-07-01", "2021-06-01"); !errors.Is(err, ErrorDateInvalid) {
+		t.Errorf("expected ErrorDateInvalid, got %v", err)
+	}
+}
+
+// Verify that random APODs come from the cache when possible and pass the filter
+func TestRandom(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if count := r.URL.Query().Get("count"); count != "10" {
+			t.Errorf("expected count=10, got %q", count)
+		}
+		json.NewEncoder(w).Encode([]*Response{
+			{Date: "2001-01-01", MediaType: "image", HdURL: "hd.jpg"},
+			{Date: "2001-01-02", MediaType: "video"},
+			{Date: "2001-01-03", MediaType: "video", Thumbnail: "thumb.jpg"},
+		})
+	}))
+	defer server.Close()
+
+	// Every day of 2020 is cached, only 2020-01-01 has an image
+	var cached strings.Builder
+	for d := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC); d.Year() == 2020; d = d.AddDate(0, 0, 1) {
+		response := &Response{Date: d.Format("2006-01-02"), MediaType: "video"}
+		if d.YearDay() == 1 {
+			response.MediaType, response.HdURL = "image", "hd.jpg"
+		}
+		json.NewEncoder(&cached).Encode(response)
+	}
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(cached.String()), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apod := NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](),
+		WithBaseURL(server.URL),
+		WithFallback(nil),
+	)
+	apod.now = func() time.Time { return time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC) }
+
+	// The cache covers 2020, where only 2020-01-01 has an image
+	for i := 0; i < 10; i++ {
+		resp, err := apod.RandomWith(context.Background(), RandomFilter{FromYear: 2020, ToYear: 2020, ImagesOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Date != "2020-01-01" {
+			t.Errorf("expected 2020-01-01, got %s", resp.Date)
+		}
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("expected no requests, got %d", n)
+	}
+
+	// A single year of the archive is too little to draw from alone
+	resp, err := apod.RandomWith(context.Background(), RandomFilter{ImagesOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Date != "2001-01-01" || requests.Load() != 1 {
+		t.Errorf("expected NASA to be asked once, got %s after %d requests", resp.Date, requests.Load())
+	}
+
+	// With nothing cached NASA is asked for a batch
+	uncached := newTestClient(server.URL)
+	resp, err = uncached.RandomWith(context.Background(), RandomFilter{MediaType: "video", ImagesOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Date != "2001-01-03" {
+		t.Errorf("expected the video with a thumbnail, got %s", resp.Date)
+	}
+
+	if _, err := apod.RandomWith(context.Background(), RandomFilter{FromYear: 2030}); !errors.Is(err, ErrorNoMatch) {
+		t.Errorf("expected ErrorNoMatch, got %v", err)
+	}
+}
+
+// Verify that random picks from the index stay within their window and find rare matches
+func TestIndexRandom(t *testing.T) {
+	ix := NewIndex()
+	for d := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC); d.Year() == 2020; d = d.AddDate(0, 0, 1) {
+		ix.Add(&Response{Date: d.Format("2006-01-02"), MediaType: "image"}, nil)
+	}
+	ix.Add(&Response{Date: "2020-06-15", MediaType: "video"}, nil)
+
+	resp, n, ok := ix.Random("2020-03-01", "2020-03-31", func(*Response, []string) bool { return true })
+	if !ok || n != 31 || resp.Date < "2020-03-01" || resp.Date > "2020-03-31" {
+		t.Errorf("expected a March APOD out of 31, got %v of %d", resp, n)
+	}
+
+	// A single video is too rare to be found by chance
+	for i := 0; i < 10; i++ {
+		resp, _, ok := ix.Random("2020-01-01", "2020-12-31", func(r *Response, _ []string) bool { return r.MediaType == "video" })
+		if !ok || resp.Date != "2020-06-15" {
+			t.Fatalf("expected the video, got %v", resp)
+		}
+	}
+
+	if _, n, ok := ix.Random("2021-01-01", "2021-12-31", func(*Response, []string) bool { return true }); ok || n != 0 {
+		t.Errorf("expected nothing in 2021, got %d", n)
+	}
+}
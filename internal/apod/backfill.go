@@ -0,0 +1,438 @@
+package apod
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+// FirstDate is the date of the first APOD
+var FirstDate = time.Date(1995, 6, 16, 0, 0, 0, 0, time.UTC)
+
+// backfillStateFile is the name the backfill state is saved under
+const backfillStateFile = "backfill.json"
+
+// backfillSaveEvery is how many finished days go by between saves of the state
+const backfillSaveEvery = 100
+
+// BackfillOptions configures a Backfill
+type BackfillOptions struct {
+	// Start is the first day to fill, zero for FirstDate
+	Start time.Time
+	// Workers is the number of range requests made in parallel
+	Workers int
+	// ChunkDays is the most days asked for in a single range request
+	ChunkDays int
+	// Pause is how long each worker waits between requests
+	Pause time.Duration
+	// Retry is the retry policy for each request
+	Retry RetryPolicy
+	// Images also downloads the image of every cached APOD
+	Images bool
+	// State is where progress is saved so that a restarted backfill can resume, nil to disable
+	State cache.FS
+}
+
+// DefaultBackfillOptions are gentle enough to run next to the bot
+var DefaultBackfillOptions = BackfillOptions{
+	Workers:   2,
+	ChunkDays: 30,
+	Pause:     5 * time.Second,
+	Retry:     FillRetry,
+}
+
+// Progress is a snapshot of a backfill
+type Progress struct {
+	// Running is true while the backfill is in progress
+	Running bool
+	// Started is when the backfill last started
+	Started time.Time
+	// Total is the number of days in the archive
+	Total int
+	// Done is the number of days that are cached or known to have no APOD
+	Done int
+	// Failed lists the dates that could not be fetched, with their last error
+	Failed map[string]string
+	// Images is the number of images downloaded by this backfill
+	Images int
+	// ImagesFailed is the number of images that could not be downloaded
+	ImagesFailed int
+}
+
+// Remaining is the number of days that still need to be fetched
+func (p Progress) Remaining() int {
+	return p.Total - p.Done
+}
+
+// backfillState is the part of a backfill's progress that survives a restart
+type backfillState struct {
+	Started      time.Time `json:"started"`
+	Total        int       `json:"total"`
+	Done         int       `json:"done"`
+	Images       int       `json:"images"`
+	ImagesFailed int       `json:"images_failed"`
+	// Failed maps dates that could not be fetched to their last error, they
+	// are retried last
+	Failed map[string]string `json:"failed"`
+}
+
+// Backfill fills the cache with every APOD in the archive using bounded
+// parallel range requests that respect the request budget
+type Backfill struct {
+	apod *APOD
+	opts BackfillOptions
+
+	mu       sync.Mutex
+	progress Progress
+	cancel   context.CancelFunc
+}
+
+// NewBackfill creates a new Backfill, loading saved progress from opts.State
+func NewBackfill(a *APOD, opts BackfillOptions) *Backfill {
+	b := &Backfill{
+		apod: a,
+		opts: opts,
+		progress: Progress{
+			Failed: make(map[string]string),
+		},
+	}
+
+	if opts.State != nil && opts.State.HasFile(backfillStateFile) {
+		var state backfillState
+		data, err := opts.State.ReadFile(backfillStateFile)
+		// An empty file is a state that was never saved
+		if err == nil && len(data) > 0 {
+			err = json.Unmarshal(data, &state)
+		}
+		if err != nil {
+			log.Println("backfill: ignoring saved state:", err)
+		} else {
+			b.progress.Started = state.Started
+			b.progress.Total = state.Total
+			b.progress.Done = state.Done
+			b.progress.Images = state.Images
+			b.progress.ImagesFailed = state.ImagesFailed
+			if state.Failed != nil {
+				b.progress.Failed = state.Failed
+			}
+		}
+	}
+
+	return b
+}
+
+// Progress returns a snapshot of the backfill's progress
+func (b *Backfill) Progress() Progress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	progress := b.progress
+	progress.Failed = make(map[string]string, len(b.progress.Failed))
+	for date, err := range b.progress.Failed {
+		progress.Failed[date] = err
+	}
+	return progress
+}
+
+// Stop cancels a running backfill
+func (b *Backfill) Stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cancel != nil {
+		b.cancel()
+	}
+}
+
+// ErrorBackfillRunning is returned when a backfill is started twice
+var ErrorBackfillRunning = errors.New("backfill is already running")
+
+// Run fills the cache, returning once every date has been attempted or ctx is done
+func (b *Backfill) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	b.mu.Lock()
+	if b.progress.Running {
+		b.mu.Unlock()
+		return ErrorBackfillRunning
+	}
+	b.cancel = cancel
+	b.progress.Running = true
+	b.progress.Started = time.Now()
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.progress.Running = false
+		b.cancel = nil
+		b.save()
+		b.mu.Unlock()
+	}()
+
+	err := b.run(ctx)
+	if err != nil {
+		log.Println("backfill: stopped:", err)
+	} else {
+		progress := b.Progress()
+		log.Printf("backfill: finished, %d/%d days, %d failed\n", progress.Done, progress.Total, len(progress.Failed))
+	}
+	return err
+}
+
+func (b *Backfill) run(ctx context.Context) error {
+	start := b.opts.Start
+	if start.IsZero() {
+		start = FirstDate
+	}
+
+	// Every day from the first APOD up to, but not including, NASA's today
+	end, _ := time.Parse("2006-01-02", b.apod.TodayDate())
+	var dates []string
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		dates = append(dates, d.Format("2006-01-02"))
+	}
+
+	b.mu.Lock()
+	b.progress.Total = len(dates)
+	b.progress.Done = 0
+	b.mu.Unlock()
+
+	var missing []string
+	for _, date := range dates {
+		if b.apod.isKnown(date) {
+			b.finished(date)
+		} else {
+			missing = append(missing, date)
+		}
+	}
+
+	// Dates that failed last time are only retried one at a time, at the end
+	first, last := b.split(missing)
+
+	// Ask for each gap with range requests
+	leftover, err := b.fillRanges(ctx, first)
+	if err != nil {
+		return err
+	}
+
+	// Then go back for the individual days that are still missing
+	if err := b.fillDays(ctx, append(leftover, last...)); err != nil {
+		return err
+	}
+
+	if b.opts.Images {
+		return b.fillImages(ctx, dates)
+	}
+	return nil
+}
+
+// split separates dates that failed in an earlier run from the rest
+func (b *Backfill) split(dates []string) (fresh, failed []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, date := range dates {
+		if _, ok := b.progress.Failed[date]; ok {
+			failed = append(failed, date)
+		} else {
+			fresh = append(fresh, date)
+		}
+	}
+	return fresh, failed
+}
+
+// chunks groups sorted dates into runs of consecutive days no longer than ChunkDays
+func (b *Backfill) chunks(dates []string) [][2]string {
+	return dateRuns(dates, b.opts.ChunkDays)
+}
+
+// fillRanges fetches dates with parallel range requests, returning the dates
+// that are still missing afterwards
+func (b *Backfill) fillRanges(ctx context.Context, dates []string) ([]string, error) {
+	chunks := b.chunks(dates)
+
+	var mu sync.Mutex
+	var leftover []string
+
+	err := b.parallel(ctx, len(chunks), func(ctx context.Context, i int) {
+		start, end := chunks[i][0], chunks[i][1]
+
+		responses, err := Retry(ctx, b.opts.Retry, func(ctx context.Context) ([]*Response, error) {
+			if err := b.apod.waitForBudget(ctx); err != nil {
+				return nil, err
+			}
+			return b.apod.rangeRequest(ctx, start, end)
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Println("backfill: error getting APODs from", start, "to", end, ":", err)
+		}
+
+		found := make(map[string]bool, len(responses))
+		for _, response := range responses {
+			found[response.Date] = true
+		}
+
+		// Chunks are runs of consecutive days
+		first, _ := time.Parse("2006-01-02", start)
+		last, _ := time.Parse("2006-01-02", end)
+		for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+			date := d.Format("2006-01-02")
+			if found[date] {
+				b.finished(date)
+			} else {
+				mu.Lock()
+				leftover = append(leftover, date)
+				mu.Unlock()
+			}
+		}
+	})
+
+	sort.Strings(leftover)
+	return leftover, err
+}
+
+// fillDays fetches dates one at a time, recording the ones that fail
+func (b *Backfill) fillDays(ctx context.Context, dates []string) error {
+	for _, date := range dates {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		_, err := Retry(ctx, b.opts.Retry, func(ctx context.Context) (*Response, error) {
+			if err := b.apod.waitForBudget(ctx); err != nil {
+				return nil, err
+			}
+			return b.apod.GetContext(ctx, date)
+		})
+
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err == nil || errors.Is(err, ErrorDateNotFound):
+			// Missing days are recorded by GetContext
+			b.finished(date)
+		default:
+			log.Println("backfill: failed to get APOD for", date, ":", err)
+			b.failed(date, err)
+		}
+
+		if err := sleep(ctx, b.opts.Pause); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillImages downloads the image of every cached date
+func (b *Backfill) fillImages(ctx context.Context, dates []string) error {
+	var todo []string
+	for _, date := range dates {
+		if b.apod.cache.Has(date) && !b.apod.imageCache.Has(date) {
+			todo = append(todo, date)
+		}
+	}
+
+	return b.parallel(ctx, len(todo), func(ctx context.Context, i int) {
+		_, err := Retry(ctx, b.opts.Retry, func(ctx context.Context) (*ImageWrapper, error) {
+			return b.apod.GetImageContext(ctx, todo[i])
+		})
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Println("backfill: failed to get image for", todo[i], ":", err)
+			}
+			b.progress.ImagesFailed++
+		} else {
+			b.progress.Images++
+		}
+		if (b.progress.Images+b.progress.ImagesFailed)%backfillSaveEvery == 0 {
+			b.save()
+		}
+	})
+}
+
+// parallel runs work for every index in [0, n) across the configured number
+// of workers, each pausing between jobs
+func (b *Backfill) parallel(ctx context.Context, n int, work func(context.Context, int)) error {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for w := 0; w < max(b.opts.Workers, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				work(ctx, i)
+				if sleep(ctx, b.opts.Pause) != nil {
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// finished records that date no longer needs to be fetched
+func (b *Backfill) finished(date string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.progress.Done++
+	_, failed := b.progress.Failed[date]
+	delete(b.progress.Failed, date)
+	if failed || b.progress.Done%backfillSaveEvery == 0 {
+		b.save()
+	}
+}
+
+// failed records that date could not be fetched
+func (b *Backfill) failed(date string, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.progress.Failed[date] = err.Error()
+	b.save()
+}
+
+// save writes the state that survives a restart, b.mu must be held
+func (b *Backfill) save() {
+	if b.opts.State == nil {
+		return
+	}
+
+	data, err := json.Marshal(backfillState{
+		Started:      b.progress.Started,
+		Total:        b.progress.Total,
+		Done:         b.progress.Done,
+		Images:       b.progress.Images,
+		ImagesFailed: b.progress.ImagesFailed,
+		Failed:       b.progress.Failed,
+	})
+	if err == nil {
+		err = b.opts.State.WriteFile(backfillStateFile, data)
+	}
+	if err != nil {
+		log.Println("backfill: error saving state:", err)
+	}
+}
@@ -0,0 +1,122 @@
+package apod
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+// Verify that a backfill fills gaps, records failures, and resumes them after a restart
+func TestBackfill(t *testing.T) {
+	var broken atomic.Bool
+	broken.Store(true)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		// Range requests skip 2021-06-30 (no APOD) and 2021-07-02 (broken)
+		if start, end := query.Get("start_date"), query.Get("end_date"); start != "" {
+			responses := []*Response{}
+			for _, date := range []string{"2021-06-29", "2021-07-01"} {
+				if date >= start && date <= end {
+					responses = append(responses, &Response{Date: date})
+				}
+			}
+			json.NewEncoder(w).Encode(responses)
+			return
+		}
+
+		switch date := query.Get("date"); {
+		case date == "2021-07-02" && !broken.Load():
+			json.NewEncoder(w).Encode(&Response{Date: date})
+		case date == "2021-07-02":
+			http.Error(w, "oops", http.StatusInternalServerError)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(""), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing, err := cache.NewAppendCache[*MissingDate](strings.NewReader(""), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apod := NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](),
+		WithBaseURL(server.URL),
+		WithFallback(nil),
+		WithMissingCache(missing),
+	)
+	apod.now = func() time.Time { return time.Date(2021, 7, 3, 12, 0, 0, 0, time.UTC) }
+
+	opts := BackfillOptions{
+		Start:     time.Date(2021, 6, 29, 0, 0, 0, 0, time.UTC),
+		Workers:   2,
+		ChunkDays: 2,
+		Retry:     RetryPolicy{MaxAttempts: 1},
+		State:     cache.NewInMemoryFS(),
+	}
+
+	backfill := NewBackfill(apod, opts)
+	if err := backfill.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	progress := backfill.Progress()
+	if progress.Total != 4 || progress.Done != 3 || progress.Remaining() != 1 {
+		t.Errorf("expected 3/4 days done, got %d/%d", progress.Done, progress.Total)
+	}
+	if _, ok := progress.Failed["2021-07-02"]; !ok || len(progress.Failed) != 1 {
+		t.Errorf("expected 2021-07-02 to fail, got %v", progress.Failed)
+	}
+	if !apodCache.Has("2021-06-29") || !apodCache.Has("2021-07-01") {
+		t.Error("expected the range requests to be cached")
+	}
+	if !apod.isKnownMissing("2021-06-30") {
+		t.Error("expected 2021-06-30 to be known missing")
+	}
+
+	// A restarted backfill remembers what failed
+	broken.Store(false)
+	backfill = NewBackfill(apod, opts)
+	progress = backfill.Progress()
+	if _, ok := progress.Failed["2021-07-02"]; !ok {
+		t.Error("expected the failure to be loaded from the saved state")
+	}
+	if progress.Total != 4 || progress.Done != 3 || progress.Started.IsZero() {
+		t.Errorf("expected 3/4 days done to be loaded from the saved state, got %d/%d", progress.Done, progress.Total)
+	}
+
+	if err := backfill.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	progress = backfill.Progress()
+	if progress.Done != 4 || len(progress.Failed) != 0 {
+		t.Errorf("expected every day to be done, got %d/%d and %v", progress.Done, progress.Total, progress.Failed)
+	}
+}
+
+// Verify that a cancelled backfill stops
+func TestBackfillCancel(t *testing.T) {
+	apod := createAPITestAPOD(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := NewBackfill(apod, DefaultBackfillOptions).Run(ctx); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
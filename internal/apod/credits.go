@@ -0,0 +1,152 @@
+package apod
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	// "Image Credit & Copyright:", "Copyright:", "©", "(c)" at the start of a credit
+	creditPrefixRegex = regexp.MustCompile(`(?i)^\s*(?:(?:image\s+)?credits?\s*(?:&|and)?\s*copyright|copyright|©|\(c\))\s*:?\s*`)
+	// Separators between contributors
+	creditSplitRegex = regexp.MustCompile(`(?i)\s*(?:[,;/&]|\band\b)\s*`)
+	// "Processing:", "Processing & License:" and other roles in front of a
+	// name, at the start of the credit or after a separator
+	creditRoleRegex = regexp.MustCompile(`(?i)(^|[;,/]\s*)[a-z]+(?:\s+[a-z]+)*(?:\s*(?:&|\band\b)\s*[a-z]+(?:\s+[a-z]+)*)*\s*:\s*`)
+	// "(Observatory)" after a name
+	creditParenRegex = regexp.MustCompile(`\s*\([^)]*\)`)
+)
+
+// NormalizeCredit tidies a copyright string from the API, removing the
+// "Copyright:" prefix and stray whitespace
+func NormalizeCredit(copyright string) string {
+	credit := strings.Join(strings.Fields(copyright), " ")
+	credit = creditPrefixRegex.ReplaceAllString(credit, "")
+	return strings.TrimSpace(credit)
+}
+
+// Contributors splits a copyright string into the names of the people and
+// organisations credited, in order and without duplicates
+func Contributors(copyright string) []string {
+	credit := creditParenRegex.ReplaceAllString(NormalizeCredit(copyright), "")
+	// Roles go before splitting, they may have separators of their own
+	credit = creditRoleRegex.ReplaceAllString(credit, "$1")
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range creditSplitRegex.Split(credit, -1) {
+		name = strings.Trim(name, " .")
+		if name == "" || seen[creditKey(name)] {
+			continue
+		}
+		seen[creditKey(name)] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+// creditKey is how names are compared, ignoring case
+func creditKey(name string) string {
+	return strings.ToLower(name)
+}
+
+// contributor is a name in the Credits index
+type contributor struct {
+	name  string
+	dates map[string]bool
+}
+
+// Credits indexes the contributors credited on each APOD. It is safe for concurrent use
+type Credits struct {
+	mu           sync.RWMutex
+	contributors map[string]*contributor
+	byDate       map[string][]string
+}
+
+// NewCredits creates an empty Credits index
+func NewCredits() *Credits {
+	return &Credits{
+		contributors: make(map[string]*contributor),
+		byDate:       make(map[string][]string),
+	}
+}
+
+// Add indexes the contributors of an APOD, replacing any earlier version of the same date
+func (c *Credits) Add(r *Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range c.byDate[r.Date] {
+		delete(c.contributors[key].dates, r.Date)
+		if len(c.contributors[key].dates) == 0 {
+			delete(c.contributors, key)
+		}
+	}
+	delete(c.byDate, r.Date)
+
+	for _, name := range Contributors(r.Copyright) {
+		key := creditKey(name)
+		if c.contributors[key] == nil {
+			c.contributors[key] = &contributor{name: name, dates: make(map[string]bool)}
+		}
+		c.contributors[key].dates[r.Date] = true
+		c.byDate[r.Date] = append(c.byDate[r.Date], key)
+	}
+}
+
+// Dates returns the dates credited to a contributor, newest first, and the
+// contributor's name as first seen
+func (c *Credits) Dates(name string) (string, []string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	contributor, ok := c.contributors[creditKey(strings.TrimSpace(name))]
+	if !ok {
+		return name, nil
+	}
+
+	dates := make([]string, 0, len(contributor.dates))
+	for date := range contributor.dates {
+		dates = append(dates, date)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dates)))
+	return contributor.name, dates
+}
+
+// Complete returns up to n contributor names containing a word that starts
+// with prefix, most credited first
+func (c *Credits) Complete(prefix string, n int) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	prefix = creditKey(strings.TrimSpace(prefix))
+	var matches []*contributor
+	for key, contributor := range c.contributors {
+		if strings.HasPrefix(key, prefix) || strings.Contains(key, " "+prefix) {
+			matches = append(matches, contributor)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if len(matches[i].dates) != len(matches[j].dates) {
+			return len(matches[i].dates) > len(matches[j].dates)
+		}
+		return matches[i].name < matches[j].name
+	})
+
+	if n > 0 && len(matches) > n {
+		matches = matches[:n]
+	}
+	names := make([]string, len(matches))
+	for i, contributor := range matches {
+		names[i] = contributor.name
+	}
+	return names
+}
+
+// Credits returns the index of contributors to cached APODs
+func (a *APOD) Credits() *Credits {
+	return a.credits
+}
@@ -0,0 +1,66 @@
+package apod
+
+import (
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+// Verify that credits are split into names
+func TestContributors(t *testing.T) {
+	tests := map[string][]string{
+		"\nJan Kuszaj, Anna Nowak\n":                            {"Jan Kuszaj", "Anna Nowak"},
+		"Image Credit & Copyright: Robert   Gendler":            {"Robert Gendler"},
+		"Copyright: Tunc Tezel (TWAN); Text: Jane Doe":          {"Tunc Tezel", "Jane Doe"},
+		"NASA, ESA and the Hubble Heritage Team":                {"NASA", "ESA", "the Hubble Heritage Team"},
+		"Andrew Klinger & andrew klinger":                       {"Andrew Klinger"},
+		"Processing: Judy Schmidt / Data: NASA/JPL":             {"Judy Schmidt", "NASA", "JPL"},
+		"NASA, ESA, Hubble; Processing & License: Judy Schmidt": {"NASA", "ESA", "Hubble", "Judy Schmidt"},
+		"Text and Image: Jane Doe, Data: ESO":                   {"Jane Doe", "ESO"},
+		"":                                                      nil,
+	}
+
+	for credit, expected := range tests {
+		if got := Contributors(credit); !slices.Equal(got, expected) {
+			t.Errorf("%q: expected %q, got %q", credit, expected, got)
+		}
+	}
+}
+
+// Verify that the credits index follows the cache
+func TestCredits(t *testing.T) {
+	cached := `{"date":"2020-01-01","copyright":"Jan Kuszaj, Anna Nowak"}
+{"date":"2021-01-01","copyright":"\nJan Kuszaj\n"}
+{"date":"2021-06-01","copyright":"Janet Jones"}
+`
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(cached), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apod := NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](), WithFallback(nil))
+
+	name, dates := apod.Credits().Dates("jan kuszaj")
+	if name != "Jan Kuszaj" || !slices.Equal(dates, []string{"2021-01-01", "2020-01-01"}) {
+		t.Errorf("expected Jan Kuszaj's two APODs, got %s %v", name, dates)
+	}
+
+	// Most credited first
+	if got := apod.Credits().Complete("ja", 10); !slices.Equal(got, []string{"Jan Kuszaj", "Janet Jones"}) {
+		t.Errorf("expected both names, got %q", got)
+	}
+	if got := apod.Credits().Complete("now", 10); !slices.Equal(got, []string{"Anna Nowak"}) {
+		t.Errorf("expected a match on the last name, got %q", got)
+	}
+
+	// A corrected credit moves the date to the right person
+	apod.cache.Add("2021-01-01", &Response{Date: "2021-01-01", Copyright: "Anna Nowak"})
+	if _, dates := apod.Credits().Dates("Jan Kuszaj"); !slices.Equal(dates, []string{"2020-01-01"}) {
+		t.Errorf("expected the corrected date to be gone, got %v", dates)
+	}
+	if _, dates := apod.Credits().Dates("Anna Nowak"); !slices.Equal(dates, []string{"2021-01-01", "2020-01-01"}) {
+		t.Errorf("expected the corrected date to be added, got %v", dates)
+	}
+}
@@ -0,0 +1,224 @@
+package apod
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// "date=2021-07-01" in an API or bot link
+	dateParamRegex = regexp.MustCompile(`(?i)\bdate=(\d{4}-\d{1,2}-\d{1,2})\b`)
+	// "ap210701.html" in an archive link, or just "ap210701"
+	archivePageRegex = regexp.MustCompile(`(?i)\bap(\d{2})(\d{2})(\d{2})(?:\.html?)?\b`)
+	// "2021-07-01", "2021/7/1", "2021.07.01"
+	isoDateRegex = regexp.MustCompile(`^(\d{4})[-/.](\d{1,2})[-/.](\d{1,2})$`)
+	// "7/1/2021", US order unless the first number can't be a month
+	usDateRegex = regexp.MustCompile(`^(\d{1,2})[-/.](\d{1,2})[-/.](\d{4})$`)
+	// "3 days ago", "a week ago"
+	agoRegex  = regexp.MustCompile(`^(\d+|a|an|one)\s+(day|week|month|year)s?\s+ago$`)
+	wordRegex = regexp.MustCompile(`[a-z]+|\d+(?:st|nd|rd|th)?`)
+)
+
+// months maps the names of the months, and their usual abbreviations, to the month
+var months = func() map[string]time.Month {
+	months := map[string]time.Month{"sept": time.September}
+	for month := time.January; month <= time.December; month++ {
+		name := strings.ToLower(month.String())
+		months[name], months[name[:3]] = month, month
+	}
+	return months
+}()
+
+// weekdays are skipped in dates like "Thursday, July 1 2021"
+var weekdays = func() map[string]bool {
+	weekdays := map[string]bool{"tues": true, "thur": true, "thurs": true}
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		weekdays[name], weekdays[name[:3]] = true, true
+	}
+	return weekdays
+}()
+
+// ResolveDate is like ParseDate, relative to NASA's today
+func (a *APOD) ResolveDate(input string) (string, error) {
+	today, _ := time.Parse("2006-01-02", a.TodayDate())
+	return ParseDate(input, today)
+}
+
+// ParseDate turns what a user typed into an APOD date (yyyy-mm-dd). It accepts
+//
+//   - yyyy-mm-dd, yyyy/m/d and m/d/yyyy
+//   - dates in words, like "July 1 2021", "1st Jul 2021" or "2021 July 1"
+//   - "today", "yesterday" and "N days/weeks/months/years ago"
+//   - links to apod.nasa.gov pages (ap210701.html) and links with a date= parameter
+//
+// Dates that can't have an APOD return a DateError, with a suggestion of the
+// nearest date that can when there is one
+func ParseDate(input string, today time.Time) (string, error) {
+	year, month, day, ok := parseDate(input, today)
+	if !ok {
+		return "", &DateError{Date: input, Err: ErrorDateInvalid}
+	}
+	return checkDate(input, year, month, day, today)
+}
+
+// ParseCalendarDate is like ParseDate but accepts any date that exists, like
+// birthdays from before the first APOD, leaving it to the caller to bound it
+func ParseCalendarDate(input string, today time.Time) (string, error) {
+	year, month, day, ok := parseDate(input, today)
+	if !ok || month < time.January || month > time.December || day < 1 || day > time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		return "", &DateError{Date: input, Err: ErrorDateInvalid}
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Format("2006-01-02"), nil
+}
+
+// parseDate reads the year, month and day out of what a user typed, see ParseDate
+func parseDate(input string, today time.Time) (year int, month time.Month, day int, ok bool) {
+	text := strings.ToLower(strings.TrimSpace(input))
+
+	switch text {
+	case "today", "now":
+		return today.Year(), today.Month(), today.Day(), true
+	case "yesterday":
+		d := today.AddDate(0, 0, -1)
+		return d.Year(), d.Month(), d.Day(), true
+	}
+
+	if match := agoRegex.FindStringSubmatch(text); match != nil {
+		n, err := strconv.Atoi(match[1])
+		if err != nil {
+			n = 1
+		}
+
+		d := today
+		switch match[2] {
+		case "day":
+			d = d.AddDate(0, 0, -n)
+		case "week":
+			d = d.AddDate(0, 0, -7*n)
+		case "month":
+			d = d.AddDate(0, -n, 0)
+		case "year":
+			d = d.AddDate(-n, 0, 0)
+		}
+		return d.Year(), d.Month(), d.Day(), true
+	}
+
+	if match := dateParamRegex.FindStringSubmatch(text); match != nil {
+		text = match[1]
+	} else if match := archivePageRegex.FindStringSubmatch(text); match != nil {
+		year = atoi(match[1])
+		if year >= 95 {
+			year += 1900
+		} else {
+			year += 2000
+		}
+		return year, time.Month(atoi(match[2])), atoi(match[3]), true
+	}
+
+	if match := isoDateRegex.FindStringSubmatch(text); match != nil {
+		return atoi(match[1]), time.Month(atoi(match[2])), atoi(match[3]), true
+	}
+
+	if match := usDateRegex.FindStringSubmatch(text); match != nil {
+		m, d := atoi(match[1]), atoi(match[2])
+		if m > 12 {
+			m, d = d, m
+		}
+		return atoi(match[3]), time.Month(m), d, true
+	}
+
+	// Dates in words, the parts may come in any order
+	for _, word := range wordRegex.FindAllString(text, -1) {
+		if isDigits(word[:1]) {
+			number := strings.TrimRight(word, "stndrh")
+			switch {
+			case len(number) == 4 && number == word && year == 0:
+				year = atoi(number)
+			case len(number) <= 2 && day == 0:
+				day = atoi(number)
+			default:
+				return 0, 0, 0, false
+			}
+			continue
+		}
+
+		switch {
+		case months[word] != 0 && month == 0:
+			month = months[word]
+		case weekdays[word], word == "of", word == "the":
+		default:
+			return 0, 0, 0, false
+		}
+	}
+	if year == 0 || month == 0 || day == 0 {
+		return 0, 0, 0, false
+	}
+	return year, month, day, true
+}
+
+// checkDate formats a date if it can have an APOD, suggesting the nearest
+// date that can otherwise
+func checkDate(input string, year int, month time.Month, day int, today time.Time) (string, error) {
+	if month < time.January || month > time.December || day < 1 {
+		return "", &DateError{Date: input, Err: ErrorDateInvalid}
+	}
+
+	// time.Date would roll "February 30" over into March
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day(); day > last {
+		suggestion := time.Date(year, month, last, 0, 0, 0, 0, time.UTC)
+		return "", &DateError{Date: input, Err: ErrorDateInvalid, Suggestion: clampDate(suggestion, today)}
+	}
+
+	d := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if d.Before(FirstDate) || d.After(today) {
+		return "", &DateError{Date: input, Err: ErrorDateInvalid, Suggestion: clampDate(d, today)}
+	}
+	return d.Format("2006-01-02"), nil
+}
+
+// clampDate returns the date closest to d between FirstDate and today
+func clampDate(d, today time.Time) string {
+	if d.Before(FirstDate) {
+		d = FirstDate
+	} else if d.After(today) {
+		d = today
+	}
+	return d.Format("2006-01-02")
+}
+
+// nearestCached returns the closest cached date within a week of date, empty if there is none
+func (a *APOD) nearestCached(date string) string {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return ""
+	}
+
+	for i := 1; i <= 7; i++ {
+		for _, near := range []time.Time{d.AddDate(0, 0, -i), d.AddDate(0, 0, i)} {
+			if a.cache.Has(near.Format("2006-01-02")) {
+				return near.Format("2006-01-02")
+			}
+		}
+	}
+	return ""
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,97 @@
+package apod
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Verify the many ways users write dates
+func TestParseDate(t *testing.T) {
+	today := time.Date(2021, 7, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]string{
+		"2021-07-01":             "2021-07-01",
+		" 2021/7/1 ":             "2021-07-01",
+		"2021.07.01":             "2021-07-01",
+		"7/1/2021":               "2021-07-01",
+		"25/12/2020":             "2020-12-25",
+		"July 1 2021":            "2021-07-01",
+		"july 1, 2021":           "2021-07-01",
+		"1st Jul 2021":           "2021-07-01",
+		"the 1st of July 2021":   "2021-07-01",
+		"2021 July 1":            "2021-07-01",
+		"Thursday, July 1, 2021": "2021-07-01",
+		"Thurs 1 Sept 2020":      "2020-09-01",
+		"today":                  "2021-07-10",
+		"Yesterday":              "2021-07-09",
+		"3 days ago":             "2021-07-07",
+		"a week ago":             "2021-07-03",
+		"2 years ago":            "2019-07-10",
+		"https://apod.nasa.gov/apod/ap210701.html": "2021-07-01",
+		"ap950616": "1995-06-16",
+		"https://api.nasa.gov/planetary/apod?date=2021-07-01&api_key=DEMO_KEY": "2021-07-01",
+	}
+	for input, expected := range tests {
+		date, err := ParseDate(input, today)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+		} else if date != expected {
+			t.Errorf("%q: expected %s, got %s", input, expected, date)
+		}
+	}
+
+	// Invalid dates suggest the nearest one that works
+	suggestions := map[string]string{
+		"2021-02-30":        "2021-02-28",
+		"1990-01-01":        "1995-06-16",
+		"2022-01-01":        "2021-07-10",
+		"tomorrow":          "",
+		"July 2021":         "",
+		"13/13/2021":        "",
+		"not a date":        "",
+		"1 2 3 2021":        "",
+		"July 1 2021 4":     "",
+		"mars 1 2021":       "",
+		"junk 1 2021":       "",
+		"sunny July 1 2021": "",
+	}
+	for input, expected := range suggestions {
+		_, err := ParseDate(input, today)
+		var dateErr *DateError
+		if !errors.As(err, &dateErr) || !errors.Is(err, ErrorDateInvalid) {
+			t.Errorf("%q: expected an invalid date, got %v", input, err)
+		} else if dateErr.Suggestion != expected {
+			t.Errorf("%q: expected suggestion %q, got %q", input, expected, dateErr.Suggestion)
+		}
+	}
+}
+
+// Verify that calendar dates may be outside the APOD archive, but must exist
+func TestParseCalendarDate(t *testing.T) {
+	today := time.Date(2021, 7, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := map[string]string{
+		"1990-07-01":  "1990-07-01",
+		"1990/07/01":  "1990-07-01",
+		"July 1 1990": "1990-07-01",
+		"29 Feb 1992": "1992-02-29",
+		"2030-01-01":  "2030-01-01",
+		"2 years ago": "2019-07-10",
+		"yesterday":   "2021-07-09",
+	}
+	for input, expected := range tests {
+		date, err := ParseCalendarDate(input, today)
+		if err != nil {
+			t.Errorf("%q: %v", input, err)
+		} else if date != expected {
+			t.Errorf("%q: expected %s, got %s", input, expected, date)
+		}
+	}
+
+	for _, input := range []string{"29 Feb 1991", "1990-13-01", "June 31 1990", "not a date"} {
+		if _, err := ParseCalendarDate(input, today); !errors.Is(err, ErrorDateInvalid) {
+			t.Errorf("%q: expected an invalid date, got %v", input, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package apod
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	// DefaultMaxImageSize is the largest image, in bytes, downloaded by default
+	DefaultMaxImageSize = 50 << 20
+	// maxImagePixels is the most pixels a downloaded image may decode to
+	maxImagePixels = 150_000_000
+	// progressStep is how many bytes are read between progress reports
+	progressStep = 1 << 20
+	// sniffLength is how much of a download is read before checking it's an image
+	sniffLength = 512
+)
+
+var (
+	// ErrorImageStatus is returned when an image host responds with an error status
+	ErrorImageStatus = errors.New("image host responded with an error")
+	// ErrorNotAnImage is returned when a download isn't an image we can decode
+	ErrorNotAnImage = errors.New("download is not an image")
+	// ErrorDownloadTooLarge is returned when an image is over the download limit
+	ErrorDownloadTooLarge = errors.New("image is over the download limit")
+)
+
+// DownloadError is returned when an image can't be downloaded
+type DownloadError struct {
+	// URL is the image that was requested
+	URL string
+	// StatusCode is the HTTP status code of the response, if there was one
+	StatusCode int
+	// ContentType is the type of the download, sniffed once any of it was read
+	ContentType string
+	// Err is ErrorImageStatus, ErrorNotAnImage or ErrorDownloadTooLarge
+	Err error
+}
+
+func (e *DownloadError) Error() string {
+	switch {
+	case e.StatusCode != 0 && e.StatusCode != http.StatusOK:
+		return fmt.Sprintf("%s: %s (%d)", e.URL, e.Err, e.StatusCode)
+	case e.ContentType != "":
+		return fmt.Sprintf("%s: %s (%s)", e.URL, e.Err, e.ContentType)
+	}
+	return fmt.Sprintf("%s: %s", e.URL, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *DownloadError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the download may succeed if it is tried again
+func (e *DownloadError) Retryable() bool {
+	if !errors.Is(e.Err, ErrorImageStatus) {
+		return false
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= 500
+}
+
+// missing reports whether the image isn't there, or isn't usable, so another
+// copy of it should be tried
+func (e *DownloadError) missing() bool {
+	switch {
+	case errors.Is(e.Err, ErrorImageStatus):
+		return e.StatusCode == http.StatusNotFound || e.StatusCode == http.StatusGone || e.StatusCode == http.StatusForbidden
+	case errors.Is(e.Err, ErrorNotAnImage), errors.Is(e.Err, ErrorDownloadTooLarge):
+		return true
+	}
+	return false
+}
+
+// DownloadProgress is reported while an image downloads
+type DownloadProgress struct {
+	// URL is the image being downloaded
+	URL string
+	// Read is the number of bytes read so far
+	Read int64
+	// Total is the size of the image, -1 if the host didn't say
+	Total int64
+	// Done is set on the last report of a successful download
+	Done bool
+}
+
+// downloader fetches images, validating and limiting them
+type downloader struct {
+	client *http.Client
+	// limit is the largest image in bytes
+	limit int64
+	// progress is called as the download goes, at most every progressStep bytes
+	progress func(DownloadProgress)
+}
+
+// defaultDownloader is used by Response.DownloadRawImage
+var defaultDownloader = downloader{client: http.DefaultClient, limit: DefaultMaxImageSize}
+
+// download creates a new ImageWrapper from an image URL. The response must
+// be a successful one with an image under the size limit
+func (d downloader) download(ctx context.Context, url string) (*ImageWrapper, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, Err: ErrorImageStatus}
+	}
+	if d.limit > 0 && resp.ContentLength > d.limit {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, ContentType: resp.Header.Get("Content-Type"), Err: ErrorDownloadTooLarge}
+	}
+
+	// Check the start of the body before reading the rest, an error page
+	// shouldn't be read in full
+	buf := &bytes.Buffer{}
+	if resp.ContentLength > 0 {
+		buf.Grow(int(resp.ContentLength))
+	}
+	body := &progressReader{r: resp.Body, progress: d.progress, report: DownloadProgress{URL: url, Total: resp.ContentLength}}
+	if _, err := io.CopyN(buf, body, sniffLength); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(buf.Bytes())
+	if !decodable(contentType) {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, ContentType: contentType, Err: ErrorNotAnImage}
+	}
+
+	// Read one byte more than the limit to tell a full read from a cut off one
+	rest := io.Reader(body)
+	if d.limit > 0 {
+		rest = io.LimitReader(body, d.limit-int64(buf.Len())+1)
+	}
+	if _, err := buf.ReadFrom(rest); err != nil {
+		return nil, err
+	}
+	if d.limit > 0 && int64(buf.Len()) > d.limit {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, ContentType: contentType, Err: ErrorDownloadTooLarge}
+	}
+	body.done()
+
+	// Don't decode images that would take gigabytes of memory
+	config, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, ContentType: contentType, Err: fmt.Errorf("%w: %w", ErrorNotAnImage, err)}
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return nil, &DownloadError{URL: url, StatusCode: resp.StatusCode, ContentType: contentType, Err: fmt.Errorf("%w: %dx%d pixels", ErrorDownloadTooLarge, config.Width, config.Height)}
+	}
+
+	return NewImageWrapper(buf.Bytes())
+}
+
+// decodable checks if a sniffed content type is an image format that is decoded
+func decodable(contentType string) bool {
+	switch strings.TrimSpace(strings.Split(contentType, ";")[0]) {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// progressReader reports how much has been read from r
+type progressReader struct {
+	r        io.Reader
+	progress func(DownloadProgress)
+	report   DownloadProgress
+	reported int64
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.report.Read += int64(n)
+	if p.progress != nil && p.report.Read-p.reported >= progressStep {
+		p.reported = p.report.Read
+		p.progress(p.report)
+	}
+	return n, err
+}
+
+// done sends the final report
+func (p *progressReader) done() {
+	if p.progress != nil {
+		p.report.Done = true
+		p.progress(p.report)
+	}
+}
@@ -0,0 +1,116 @@
+package apod
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// newImageServer serves a small png at /small.png, a large one at /large.png,
+// the large one without a Content-Length at /chunked.png, an html page at
+// /page.html and a 404 everywhere else
+func newImageServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	encode := func(w, h int) []byte {
+		buf := &bytes.Buffer{}
+		png.Encode(buf, noiseImage(w, h).Image)
+		return buf.Bytes()
+	}
+	small, large := encode(16, 16), encode(256, 256)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small.png":
+			w.Write(small)
+		case "/large.png":
+			w.Header().Set("Content-Length", strconv.Itoa(len(large)))
+			w.Write(large)
+		case "/chunked.png":
+			w.Header().Set("Content-Type", "image/png")
+			for i := 0; i < len(large); i += 1024 {
+				w.Write(large[i:min(i+1024, len(large))])
+				w.(http.Flusher).Flush()
+			}
+		case "/page.html":
+			w.Write([]byte("<!DOCTYPE html><html><body>Not here</body></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Verify that downloads are checked before they are decoded
+func TestDownload(t *testing.T) {
+	server := newImageServer(t)
+	d := downloader{client: server.Client(), limit: 64 * 1024}
+
+	tests := map[string]error{
+		"/small.png":   nil,
+		"/large.png":   ErrorDownloadTooLarge,
+		"/chunked.png": ErrorDownloadTooLarge,
+		"/page.html":   ErrorNotAnImage,
+		"/gone.png":    ErrorImageStatus,
+	}
+	for path, expected := range tests {
+		_, err := d.download(context.Background(), server.URL+path)
+		if !errors.Is(err, expected) {
+			t.Errorf("%s: expected %v, got %v", path, expected, err)
+		}
+		if err != nil && IsRetryable(err) {
+			t.Errorf("%s: expected %v not to be retried", path, err)
+		}
+	}
+
+	// Progress is reported every MiB and once at the end
+	var reports []DownloadProgress
+	d = downloader{client: server.Client(), limit: DefaultMaxImageSize, progress: func(p DownloadProgress) {
+		reports = append(reports, p)
+	}}
+	if _, err := d.download(context.Background(), server.URL+"/large.png"); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) == 0 || !reports[len(reports)-1].Done || reports[len(reports)-1].Read != reports[len(reports)-1].Total {
+		t.Errorf("expected a final report with every byte read, got %+v", reports)
+	}
+}
+
+// Verify that the regular image is used when the HD one is missing or too large
+func TestDownloadFallback(t *testing.T) {
+	server := newImageServer(t)
+	d := downloader{client: server.Client(), limit: 64 * 1024}
+
+	tests := []struct {
+		hdurl, url string
+		expected   error
+	}{
+		{"/small.png", "/gone.png", nil},
+		{"/gone.png", "/small.png", nil},
+		{"/large.png", "/small.png", nil},
+		{"/page.html", "/small.png", nil},
+		{"/gone.png", "/large.png", ErrorDownloadTooLarge},
+		{"", "/small.png", nil},
+	}
+	for _, test := range tests {
+		response := &Response{MediaType: "image", URL: server.URL + test.url}
+		if test.hdurl != "" {
+			response.HdURL = server.URL + test.hdurl
+		}
+
+		wrapper, err := response.downloadRawImage(context.Background(), d)
+		if !errors.Is(err, test.expected) {
+			t.Errorf("%s, %s: expected %v, got %v", test.hdurl, test.url, test.expected, err)
+		}
+		if err == nil && wrapper.Image.Bounds() != image.Rect(0, 0, 16, 16) {
+			t.Errorf("%s, %s: expected the small image, got %v", test.hdurl, test.url, wrapper.Image.Bounds())
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package apod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrorDateNotFound is returned when given date is not found on the NASA API
+	ErrorDateNotFound = fmt.Errorf("date not found in NASA API")
+	// ErrorDateInvalid is returned when given date is not in the correct format
+	ErrorDateInvalid = fmt.Errorf("date is not in the correct format, use yyyy-mm-dd")
+	// ErrorRateLimited matches APIErrors for requests rejected by the rate limit
+	ErrorRateLimited = errors.New("NASA API rate limit exceeded")
+	// ErrorUnauthorized matches APIErrors for requests rejected because of the API key
+	ErrorUnauthorized = errors.New("NASA API key rejected")
+	// ErrorUnavailable matches APIErrors for requests that failed on NASA's side
+	ErrorUnavailable = errors.New("NASA API unavailable")
+	// ErrorNoMatch is returned when no APOD passes a RandomFilter
+	ErrorNoMatch = errors.New("no APOD matches the filter")
+	// ErrorImageTooLarge is returned when an image can't be resized under the size limit
+	ErrorImageTooLarge = errors.New("image can't be made small enough")
+)
+
+// maxErrorBody is how much of a failed response's body is kept in an APIError
+const maxErrorBody = 1024
+
+// APIError is returned when the NASA API responds with an unexpected status code
+type APIError struct {
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Status is the HTTP status line of the response, e.g. "503 Service Unavailable"
+	Status string
+	// Body is the start of the response body, NASA explains most failures here
+	Body string
+	// Date is the APOD date that was requested, empty for today and ranges
+	Date string
+	// RateLimit is the request budget reported with the response, if any
+	RateLimit RateLimit
+	// RetryAfter is how long the API asked us to wait before trying again, zero if unset
+	RetryAfter time.Duration
+}
+
+// newAPIError creates an APIError from a failed response
+func newAPIError(resp *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	limit, _ := parseRateLimit(resp.Header)
+
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       string(body),
+		RateLimit:  limit,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *APIError) Error() string {
+	if e.Date != "" {
+		return fmt.Sprintf("NASA API Failure for %s: %s", e.Date, e.Status)
+	}
+	return fmt.Sprintf("NASA API Failure: %s", e.Status)
+}
+
+// Retryable reports whether the request may succeed if it is tried again
+func (e *APIError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusRequestTimeout:
+		return true
+	}
+	return e.StatusCode >= 500
+}
+
+// Is lets errors.Is match an APIError against ErrorRateLimited,
+// ErrorUnauthorized and ErrorUnavailable
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrorRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrorUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrorUnavailable:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// DateError is returned when a date is invalid or has no APOD
+type DateError struct {
+	// Date is the requested date
+	Date string
+	// Err is ErrorDateInvalid or ErrorDateNotFound
+	Err error
+	// Suggestion is a nearby date (yyyy-mm-dd) that would work, empty if there is none
+	Suggestion string
+}
+
+func (e *DateError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("%s: %s, try %s", e.Date, e.Err, e.Suggestion)
+	}
+	return fmt.Sprintf("%s: %s", e.Date, e.Err)
+}
+
+// Unwrap returns the underlying ErrorDateInvalid or ErrorDateNotFound
+func (e *DateError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorKind groups the errors returned by the client by what a user should be told
+type ErrorKind int
+
+const (
+	// KindUnknown is any error not covered below
+	KindUnknown ErrorKind = iota
+	// KindTimeout means NASA or an image host took too long to respond
+	KindTimeout
+	// KindRateLimited means the bot ran out of NASA API requests for now
+	KindRateLimited
+	// KindUnauthorized means NASA rejected the bot's API key
+	KindUnauthorized
+	// KindUnavailable means the NASA API is down
+	KindUnavailable
+	// KindDateInvalid means the requested date is malformed or out of range
+	KindDateInvalid
+	// KindDateNotFound means there is no APOD for the requested date
+	KindDateNotFound
+	// KindNotPublished means today's APOD is not up yet
+	KindNotPublished
+	// KindNoMatch means no APOD passes the requested filters
+	KindNoMatch
+	// KindArchiveUnavailable means apod.nasa.gov is down
+	KindArchiveUnavailable
+)
+
+// KindOf classifies an error returned by the client
+func KindOf(err error) ErrorKind {
+	switch {
+	case err == nil:
+		return KindUnknown
+	case errors.Is(err, context.DeadlineExceeded):
+		return KindTimeout
+	case errors.Is(err, ErrorRateLimited):
+		return KindRateLimited
+	case errors.Is(err, ErrorUnauthorized):
+		return KindUnauthorized
+	case errors.Is(err, ErrorUnavailable):
+		return KindUnavailable
+	case errors.Is(err, ErrorDateInvalid):
+		return KindDateInvalid
+	case errors.Is(err, ErrorDateNotFound):
+		return KindDateNotFound
+	case errors.Is(err, ErrorNotPublished):
+		return KindNotPublished
+	case errors.Is(err, ErrorNoMatch):
+		return KindNoMatch
+	case errors.Is(err, ErrorArchiveUnavailable):
+		return KindArchiveUnavailable
+	}
+	return KindUnknown
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(header); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
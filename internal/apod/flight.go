@@ -0,0 +1,55 @@
+package apod
+
+import (
+	"context"
+	"sync"
+)
+
+// flight is a single in-progress call shared by every caller asking for the same key
+type flight[T any] struct {
+	done chan struct{}
+	val  T
+	err  error
+}
+
+// flightGroup merges concurrent calls for the same key into a single call
+type flightGroup[T any] struct {
+	mu      sync.Mutex
+	flights map[string]*flight[T]
+}
+
+// do runs fn once for every group of concurrent callers using the same key,
+// sharing its result between them
+//
+// fn keeps running when a caller's ctx is done, so that the other callers still
+// get a result, but that caller returns early with ctx's error
+func (g *flightGroup[T]) do(ctx context.Context, key string, fn func(context.Context) (T, error)) (T, error) {
+	g.mu.Lock()
+	if g.flights == nil {
+		g.flights = make(map[string]*flight[T])
+	}
+
+	f, ok := g.flights[key]
+	if !ok {
+		f = &flight[T]{done: make(chan struct{})}
+		g.flights[key] = f
+
+		go func() {
+			f.val, f.err = fn(context.WithoutCancel(ctx))
+
+			g.mu.Lock()
+			delete(g.flights, key)
+			g.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
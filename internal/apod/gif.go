@@ -0,0 +1,160 @@
+package apod
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"math"
+)
+
+// maxGIFFrameStep is the most frames Resize merges into one, keeping every
+// third frame of an animation at most
+const maxGIFFrameStep = 3
+
+// minGIFFrames is the fewest frames Resize leaves in an animation
+const minGIFFrames = 2
+
+// gifDropRatio is how far over the limit an animation must be before Resize
+// drops frames rather than only scaling them
+const gifDropRatio = 0.6
+
+// decodeAnimation decodes every frame of a GIF, returning nil if it isn't animated
+func decodeAnimation(buf []byte) *gif.GIF {
+	g, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil || len(g.Image) < 2 {
+		return nil
+	}
+	return g
+}
+
+// resizeGIF shrinks an animated GIF to at most maxBytes while keeping it
+// animated. Frames are dropped first, adding their delays to the frame before,
+// and then scaled down with the aspect ratio kept
+func (i *ImageWrapper) resizeGIF(maxBytes int) error {
+	frames := len(i.GIF.Image)
+	bounds := gifBounds(i.GIF)
+
+	step, w, h := 1, bounds.Dx(), bounds.Dy()
+	for {
+		g := buildGIF(i.GIF, step, w, h)
+		buf := jpegBuffers.Get().(*bytes.Buffer)
+		buf.Reset()
+		if err := gif.EncodeAll(buf, g); err != nil {
+			jpegBuffers.Put(buf)
+			return err
+		}
+
+		size := buf.Len()
+		fits := size <= maxBytes
+		if fits {
+			i.Image = g.Image[0]
+			i.GIF = g
+			i.Format = "gif"
+			i.Bytes = bytes.Clone(buf.Bytes())
+		}
+		jpegBuffers.Put(buf)
+		if fits {
+			return nil
+		}
+
+		// Dropping frames saves the most, as long as enough are left
+		ratio := float64(maxBytes) / float64(size)
+		if ratio < gifDropRatio && step < maxGIFFrameStep && (frames+step)/(step+1) >= minGIFFrames {
+			step++
+			continue
+		}
+
+		scale := math.Sqrt(ratio) * 0.95
+		w = int(math.Round(float64(w) * scale))
+		h = int(math.Round(float64(w) * float64(bounds.Dy()) / float64(bounds.Dx())))
+		if w < minResizeDimension || h < minResizeDimension {
+			return fmt.Errorf("%w: %d bytes as a %d frame animation, want %d", ErrorImageTooLarge, size, len(g.Image), maxBytes)
+		}
+	}
+}
+
+// gifBounds returns the canvas an animation is drawn on
+func gifBounds(g *gif.GIF) image.Rectangle {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if bounds.Empty() {
+		for _, frame := range g.Image {
+			bounds = bounds.Union(frame.Bounds())
+		}
+	}
+	return bounds
+}
+
+// renderGIF draws each frame of an animation onto a full canvas, following
+// the disposal methods, so frames can be scaled and dropped on their own.
+// Frames are rendered one at a time and passed to visit, the canvas is only
+// valid until visit returns
+func renderGIF(g *gif.GIF, visit func(n int, frame *image.RGBA)) {
+	canvas := image.NewRGBA(gifBounds(g))
+	for n, frame := range g.Image {
+		var disposal byte
+		if n < len(g.Disposal) {
+			disposal = g.Disposal[n]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = cloneRGBA(canvas)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		visit(n, canvas)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+}
+
+// buildGIF creates an animation from every step-th frame of g scaled to w×h,
+// dithered back to the palettes of the original frames
+func buildGIF(g *gif.GIF, step, w, h int) *gif.GIF {
+	out := &gif.GIF{
+		LoopCount: g.LoopCount,
+		Config:    image.Config{Width: w, Height: h},
+	}
+	if palette, ok := g.Config.ColorModel.(color.Palette); ok {
+		out.Config.ColorModel = palette
+	}
+
+	renderGIF(g, func(n int, frame *image.RGBA) {
+		if n%step != 0 {
+			return
+		}
+
+		var delay int
+		for k := n; k < min(n+step, len(g.Image)); k++ {
+			delay += g.Delay[k]
+		}
+
+		var img image.Image = frame
+		if bounds := frame.Bounds(); bounds.Dx() != w || bounds.Dy() != h {
+			img = scaleImage(img, w, h)
+		}
+
+		paletted := image.NewPaletted(image.Rect(0, 0, w, h), g.Image[n].Palette)
+		draw.FloydSteinberg.Draw(paletted, paletted.Rect, img, img.Bounds().Min)
+
+		out.Image = append(out.Image, paletted)
+		out.Delay = append(out.Delay, delay)
+		out.Disposal = append(out.Disposal, gif.DisposalNone)
+	})
+	return out
+}
+
+// cloneRGBA copies an image
+func cloneRGBA(img *image.RGBA) *image.RGBA {
+	clone := *img
+	clone.Pix = bytes.Clone(img.Pix)
+	return &clone
+}
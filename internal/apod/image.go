@@ -2,14 +2,15 @@ package apod
 
 import (
 	"bytes"
+	"fmt"
 	"image"
-	"io"
-	"net/http"
+	"math"
+	"sync"
 
 	// Include jpeg image decoder
 	"image/jpeg"
 	// Include gif image decoder
-	_ "image/gif"
+	"image/gif"
 	// Include png image decoder
 	_ "image/png"
 
@@ -40,54 +41,296 @@ type ImageWrapper struct {
 	Format string
 	// Bytes is the binary representation of the image
 	Bytes []byte
+	// GIF holds every frame of an animated GIF, Image is its first frame. It
+	// is nil for other images
+	GIF *gif.GIF
 }
 
 // NewImageWrapper creates a new ImageWrapper from binary data.
 func NewImageWrapper(buf []byte) (*ImageWrapper, error) {
 	img, format, err := image.Decode(bytes.NewReader(buf))
-	return &ImageWrapper{
+	wrapper := &ImageWrapper{
 		Image:  img,
 		Format: format,
 		Bytes:  buf,
-	}, err
+	}
+	if err == nil && format == "gif" {
+		wrapper.GIF = decodeAnimation(buf)
+	}
+	return wrapper, err
 }
 
-// downloadImage creates a new ImageWrapper from an image URL.
-func downloadImage(url string) (*ImageWrapper, error) {
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// minResizeQuality is the lowest jpeg quality Resize uses before scaling the
+// image down, below it compression artifacts hurt more than fewer pixels
+const minResizeQuality = 50
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+// minResizeDimension is the smallest width or height Resize scales an image to
+const minResizeDimension = 16
+
+// probeDimension is the longest side of the downsampled copy Resize uses to
+// estimate how big each quality encodes
+const probeDimension = 256
 
-	return NewImageWrapper(body)
+// maxEstimates is how many times Resize encodes at an estimated quality
+// before falling back to plain bisection
+const maxEstimates = 4
+
+// jpegBuffers are reused between encodes, of gifs too, large images need large buffers
+var jpegBuffers = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
 }
 
-// Resize returns a new ImageWrapper with the original image converted to jpeg
-// and reduces quality until the image is under maxBytes.
+// Resize converts the image to jpeg so it is at most maxBytes, first lowering
+// the quality and then, if that isn't enough, scaling it down with its aspect
+// ratio kept. It returns ErrorImageTooLarge if the image can't be made to fit
+//
+// The quality is found with a binary search, starting from an estimate made
+// on a small downsampled copy of the image. Animated GIFs stay animated GIFs
 func (i *ImageWrapper) Resize(maxBytes int) error {
 	if len(i.Bytes) <= maxBytes {
 		return nil
 	}
+	if i.Image == nil {
+		return fmt.Errorf("%w: the image could not be decoded", ErrorImageTooLarge)
+	}
+	if i.GIF != nil {
+		return i.resizeGIF(maxBytes)
+	}
+
+	// The jpeg encoder is much faster on these types than on the rest
+	original := encodable(i.Image)
+	probe := newSizeProbe(original)
 
-	// re-encode the image with lower quality until it is under the max size
-	for quality := 100; quality > 0; quality -= 5 {
-		buf := &bytes.Buffer{}
-		err := jpeg.Encode(buf, i.Image, &jpeg.Options{Quality: quality})
+	img := original
+	for {
+		bounds := img.Bounds()
+		pixels := bounds.Dx() * bounds.Dy()
+
+		// Until a full encode has calibrated the probe, its estimate is only a hint
+		guess, fits, err := probe.quality(maxBytes, pixels)
 		if err != nil {
 			return err
 		}
+		if fits || !probe.calibrated {
+			data, err := searchQuality(img, maxBytes, guess, probe)
+			if err != nil {
+				return err
+			}
+			if data != nil {
+				i.Image = img
+				i.Format = "jpeg"
+				i.Bytes = data
+				return nil
+			}
+		}
+
+		// Size grows with the number of pixels, aim a little under the limit.
+		// Always scale the original so the filter's blur doesn't build up
+		size, err := probe.estimate(minResizeQuality, pixels)
+		if err != nil {
+			return err
+		}
+		ratio := math.Sqrt(float64(maxBytes)/float64(size)) * 0.95
+		w := int(math.Round(float64(bounds.Dx()) * ratio))
+		h := int(math.Round(float64(w) * float64(bounds.Dy()) / float64(bounds.Dx())))
+		if w < minResizeDimension || h < minResizeDimension {
+			return fmt.Errorf("%w: about %d bytes at %dx%d, want %d", ErrorImageTooLarge, size, bounds.Dx(), bounds.Dy(), maxBytes)
+		}
+
+		img = scaleImage(original, w, h)
+	}
+}
+
+// searchQuality binary searches for the highest quality, down to
+// minResizeQuality, that encodes img in at most maxBytes. Instead of the
+// middle quality, it first tries guess and then the probe's estimates, which
+// each full encode makes more accurate. It returns nil when no quality is
+// small enough
+func searchQuality(img image.Image, maxBytes, guess int, probe *sizeProbe) ([]byte, error) {
+	bounds := img.Bounds()
+	pixels := bounds.Dx() * bounds.Dy()
+
+	var best *bytes.Buffer
+	defer func() {
+		if best != nil {
+			jpegBuffers.Put(best)
+		}
+	}()
+
+	lo, hi := minResizeQuality, 100
+	quality := min(max(guess, lo), hi)
+	for tries := 0; lo <= hi; tries++ {
+		buf := jpegBuffers.Get().(*bytes.Buffer)
+		buf.Reset()
+		if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+			jpegBuffers.Put(buf)
+			return nil, err
+		}
+		probe.calibrate(quality, pixels, buf.Len())
 
 		if buf.Len() <= maxBytes {
-			i.Bytes = buf.Bytes()
-			return nil
+			if best != nil {
+				jpegBuffers.Put(best)
+			}
+			best = buf
+			lo = quality + 1
+		} else {
+			jpegBuffers.Put(buf)
+			hi = quality - 1
+		}
+
+		// The probe is calibrated by now, its estimate is usually right or
+		// one off. Bisect if it keeps missing
+		quality = (lo + hi + 1) / 2
+		if tries < maxEstimates {
+			estimate, _, err := probe.quality(maxBytes, pixels)
+			if err != nil {
+				return nil, err
+			}
+			quality = min(max(estimate, lo), hi)
+		}
+	}
+
+	if best == nil {
+		return nil, nil
+	}
+	return bytes.Clone(best.Bytes()), nil
+}
+
+// encodable returns img as a type the jpeg encoder has a fast path for
+func encodable(img image.Image) image.Image {
+	switch img.(type) {
+	case *image.YCbCr, *image.RGBA, *image.Gray:
+		return img
+	}
+	return toRGBA(img)
+}
+
+// sizeProbe estimates how big an image encodes at each jpeg quality from a
+// downsampled copy, corrected by the sizes of full encodes
+type sizeProbe struct {
+	img    image.Image
+	pixels int
+	// sizes are the probe's encoded sizes by quality
+	sizes map[int]int
+	// corrections scale the probe's bytes per pixel to the full image's, by
+	// quality, for the size last encoded
+	corrections  map[int]float64
+	correctedFor int
+	// correction is the latest correction, used until one is made for a new size
+	correction float64
+	calibrated bool
+}
+
+// newSizeProbe creates a sizeProbe from img
+func newSizeProbe(img image.Image) *sizeProbe {
+	bounds := img.Bounds()
+	if longest := max(bounds.Dx(), bounds.Dy()); longest > probeDimension {
+		ratio := float64(probeDimension) / float64(longest)
+		w := max(int(math.Round(float64(bounds.Dx())*ratio)), 1)
+		h := max(int(math.Round(float64(bounds.Dy())*ratio)), 1)
+		img = scaleImage(img, w, h)
+		bounds = img.Bounds()
+	}
+
+	return &sizeProbe{
+		img:         img,
+		pixels:      bounds.Dx() * bounds.Dy(),
+		sizes:       make(map[int]int),
+		corrections: make(map[int]float64),
+		correction:  1,
+	}
+}
+
+// probeSize encodes the probe at quality, once
+func (p *sizeProbe) probeSize(quality int) (int, error) {
+	if size, ok := p.sizes[quality]; ok {
+		return size, nil
+	}
+
+	buf := jpegBuffers.Get().(*bytes.Buffer)
+	defer jpegBuffers.Put(buf)
+	buf.Reset()
+	if err := jpeg.Encode(buf, p.img, &jpeg.Options{Quality: quality}); err != nil {
+		return 0, err
+	}
+	p.sizes[quality] = buf.Len()
+	return buf.Len(), nil
+}
+
+// estimate returns the expected encoded size at quality of an image with pixels pixels
+func (p *sizeProbe) estimate(quality, pixels int) (int, error) {
+	size, err := p.probeSize(quality)
+	if err != nil {
+		return 0, err
+	}
+	return int(float64(size) * float64(pixels) / float64(p.pixels) * p.correctionAt(quality)), nil
+}
+
+// calibrate corrects later estimates with the actual size of a full encode
+func (p *sizeProbe) calibrate(quality, pixels, size int) {
+	probe, err := p.probeSize(quality)
+	if err != nil || probe == 0 {
+		return
+	}
+
+	if pixels != p.correctedFor {
+		clear(p.corrections)
+		p.correctedFor = pixels
+	}
+	p.correction = float64(size) / (float64(probe) * float64(pixels) / float64(p.pixels))
+	p.corrections[quality] = p.correction
+	p.calibrated = true
+}
+
+// correctionAt interpolates the correction at quality between the nearest
+// calibrated qualities, the probe is off by different amounts at each
+func (p *sizeProbe) correctionAt(quality int) float64 {
+	below, above := -1, -1
+	for q := range p.corrections {
+		if q <= quality && q > below {
+			below = q
+		}
+		if q >= quality && (above == -1 || q < above) {
+			above = q
 		}
 	}
 
-	return nil
+	switch {
+	case below == -1 && above == -1:
+		return p.correction
+	case below == -1:
+		return p.corrections[above]
+	case above == -1 || above == below:
+		return p.corrections[below]
+	}
+	t := float64(quality-below) / float64(above-below)
+	return p.corrections[below]*(1-t) + p.corrections[above]*t
+}
+
+// quality returns the highest quality, down to minResizeQuality, expected to
+// encode an image with pixels pixels in at most maxBytes. It returns false
+// with minResizeQuality when none are expected to
+func (p *sizeProbe) quality(maxBytes, pixels int) (int, bool, error) {
+	lo, hi := minResizeQuality, 100
+	found := false
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		size, err := p.estimate(mid, pixels)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if size <= maxBytes {
+			found = true
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	if !found {
+		return minResizeQuality, false, nil
+	}
+	return hi, true, nil
 }
@@ -1,6 +1,18 @@
 package apod
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -16,7 +28,13 @@ func NewPair[T, U any](first T, second U) Pair[T, U] {
 
 const DiscordMaxImageSize = 8 * 1024 * 1024
 
+// Verify that images that broke Resize before still fit, downloaded from
+// apod.nasa.gov when APOD_NETWORK_TESTS is set
 func TestRegressions(t *testing.T) {
+	if os.Getenv("APOD_NETWORK_TESTS") == "" {
+		t.Skip("set APOD_NETWORK_TESTS to download the images from apod.nasa.gov")
+	}
+
 	urls := []Pair[string, string]{
 		// Sep 26, 2023 - Image large image failed to resize properly
 		NewPair("2023-09-26", "https://apod.nasa.gov/apod/image/2309/BlueHorse_Grelin_9342.jpg"),
@@ -24,10 +42,10 @@ func TestRegressions(t *testing.T) {
 
 	for _, pair := range urls {
 		start := time.Now()
-		wrapper, err := downloadImage(pair.second)
+		wrapper, err := defaultDownloader.download(context.Background(), pair.second)
 
 		if err != nil {
-			t.Error(err)
+			t.Fatal(err)
 		}
 
 		t.Log("Downloaded image in", time.Since(start))
@@ -42,3 +60,258 @@ func TestRegressions(t *testing.T) {
 		t.Log("Resized image in", time.Since(start))
 	}
 }
+
+// noiseImage creates an image that compresses badly
+func noiseImage(w, h int) *ImageWrapper {
+	rng := rand.New(rand.NewPCG(1, 2))
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.IntN(256))
+	}
+
+	buf := &bytes.Buffer{}
+	png.Encode(buf, img)
+	wrapper, _ := NewImageWrapper(buf.Bytes())
+	return wrapper
+}
+
+// Verify that images too big at any quality are scaled down, keeping their aspect ratio
+func TestResize(t *testing.T) {
+	wrapper := noiseImage(600, 300)
+	if err := wrapper.Resize(40 * 1024); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wrapper.Bytes) > 40*1024 {
+		t.Errorf("expected at most %d bytes, got %d", 40*1024, len(wrapper.Bytes))
+	}
+	if wrapper.Format != "jpeg" {
+		t.Errorf("expected a jpeg, got %s", wrapper.Format)
+	}
+
+	decoded, format, err := image.Decode(bytes.NewReader(wrapper.Bytes))
+	if err != nil || format != "jpeg" {
+		t.Fatal("expected the bytes to be a jpeg", err)
+	}
+	bounds := decoded.Bounds()
+	if bounds.Dx() >= 600 || math.Abs(float64(bounds.Dx())/float64(bounds.Dy())-2) > 0.01 {
+		t.Errorf("expected a smaller 2:1 image, got %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	// No image fits in 100 bytes
+	wrapper = noiseImage(600, 300)
+	original := wrapper.Bytes
+	if err := wrapper.Resize(100); !errors.Is(err, ErrorImageTooLarge) {
+		t.Errorf("expected ErrorImageTooLarge, got %v", err)
+	}
+	if !bytes.Equal(wrapper.Bytes, original) {
+		t.Error("expected a failed resize to leave the image alone")
+	}
+}
+
+// Verify that scaling keeps flat colors flat
+func TestScaleImage(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 97, 61))
+	for i := 0; i < len(img.Pix); i += 4 {
+		copy(img.Pix[i:], []uint8{200, 100, 50, 255})
+	}
+
+	scaled := scaleImage(img, 30, 19)
+	if scaled.Bounds().Dx() != 30 || scaled.Bounds().Dy() != 19 {
+		t.Fatalf("expected 30x19, got %v", scaled.Bounds())
+	}
+	for i := 0; i < len(scaled.Pix); i += 4 {
+		if !bytes.Equal(scaled.Pix[i:i+4], []uint8{200, 100, 50, 255}) {
+			t.Fatalf("expected a flat color, got %v at %d", scaled.Pix[i:i+4], i/4)
+		}
+	}
+}
+
+// loadFixture decodes an image from testdata/images
+func loadFixture(tb testing.TB, name string) *ImageWrapper {
+	tb.Helper()
+	buf, err := os.ReadFile(filepath.Join("testdata", "images", name))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	wrapper, err := NewImageWrapper(buf)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return wrapper
+}
+
+// linearResize is how Resize used to work, stepping the quality down by 5 on
+// the full image. BenchmarkResize compares against it
+func linearResize(i *ImageWrapper, maxBytes int) error {
+	img := i.Image
+	for {
+		var buf *bytes.Buffer
+		for quality := 100; quality >= minResizeQuality; quality -= 5 {
+			buf = &bytes.Buffer{}
+			if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality}); err != nil {
+				return err
+			}
+			if buf.Len() <= maxBytes {
+				i.Image, i.Format, i.Bytes = img, "jpeg", buf.Bytes()
+				return nil
+			}
+		}
+
+		ratio := math.Sqrt(float64(maxBytes)/float64(buf.Len())) * 0.95
+		bounds := img.Bounds()
+		w := int(math.Round(float64(bounds.Dx()) * ratio))
+		h := int(math.Round(float64(w) * float64(bounds.Dy()) / float64(bounds.Dx())))
+		if w < minResizeDimension || h < minResizeDimension {
+			return ErrorImageTooLarge
+		}
+		img = scaleImage(i.Image, w, h)
+	}
+}
+
+// resizeLimits are fractions of a fixture's size. The first two are met by
+// lowering the quality and the last needs scaling
+var resizeLimits = map[string]float64{
+	"high-quality": 0.6,
+	"low-quality":  0.35,
+	"scale":        0.1,
+}
+
+// Verify that fixtures are resized under each limit
+func TestResizeFixtures(t *testing.T) {
+	fixture := loadFixture(t, "nebula.jpg")
+	for name, fraction := range resizeLimits {
+		maxBytes := int(float64(len(fixture.Bytes)) * fraction)
+		wrapper := *fixture
+		if err := wrapper.Resize(maxBytes); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(wrapper.Bytes) > maxBytes {
+			t.Errorf("%s: expected at most %d bytes, got %d", name, maxBytes, len(wrapper.Bytes))
+		}
+		// Not too far under the limit either
+		if len(wrapper.Bytes) < maxBytes/2 {
+			t.Errorf("%s: expected close to %d bytes, got %d", name, maxBytes, len(wrapper.Bytes))
+		}
+	}
+}
+
+func BenchmarkResize(b *testing.B) {
+	fixture := loadFixture(b, "nebula.jpg")
+	resizers := map[string]func(*ImageWrapper, int) error{
+		"search": (*ImageWrapper).Resize,
+		"linear": linearResize,
+	}
+
+	for limit, fraction := range resizeLimits {
+		maxBytes := int(float64(len(fixture.Bytes)) * fraction)
+		for name, resize := range resizers {
+			b.Run(limit+"/"+name, func(b *testing.B) {
+				b.ReportAllocs()
+				for n := 0; n < b.N; n++ {
+					wrapper := *fixture
+					if err := resize(&wrapper, maxBytes); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// noiseGIF creates an animation of noisy frames, the second one only covering
+// the top left corner
+func noiseGIF(w, h, frames int) []byte {
+	rng := rand.New(rand.NewPCG(3, 4))
+	palette := color.Palette{color.Transparent}
+	for n := 0; n < 63; n++ {
+		palette = append(palette, color.RGBA{uint8(n * 4), uint8(255 - n*4), uint8(n * 2), 255})
+	}
+
+	g := &gif.GIF{LoopCount: 0}
+	for n := 0; n < frames; n++ {
+		bounds := image.Rect(0, 0, w, h)
+		if n == 1 {
+			bounds = image.Rect(0, 0, w/2, h/2)
+		}
+		frame := image.NewPaletted(bounds, palette)
+		for i := range frame.Pix {
+			frame.Pix[i] = uint8(1 + rng.IntN(len(palette)-1))
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+
+	buf := &bytes.Buffer{}
+	gif.EncodeAll(buf, g)
+	return buf.Bytes()
+}
+
+// Verify that animated GIFs stay animated when resized
+func TestResizeGIF(t *testing.T) {
+	buf := noiseGIF(240, 160, 12)
+	wrapper, err := NewImageWrapper(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrapper.GIF == nil || len(wrapper.GIF.Image) != 12 {
+		t.Fatal("expected an animation with 12 frames")
+	}
+
+	// Small enough already, uploaded as it is
+	if err := wrapper.Resize(len(buf)); err != nil || !bytes.Equal(wrapper.Bytes, buf) {
+		t.Error("expected the animation to be left alone", err)
+	}
+
+	for _, maxBytes := range []int{len(buf) * 3 / 4, len(buf) / 4, len(buf) / 20} {
+		wrapper, _ := NewImageWrapper(buf)
+		if err := wrapper.Resize(maxBytes); err != nil {
+			t.Fatal(err)
+		}
+		if len(wrapper.Bytes) > maxBytes {
+			t.Errorf("expected at most %d bytes, got %d", maxBytes, len(wrapper.Bytes))
+		}
+
+		resized, err := gif.DecodeAll(bytes.NewReader(wrapper.Bytes))
+		if err != nil || wrapper.Format != "gif" {
+			t.Fatal("expected a gif", err)
+		}
+		if len(resized.Image) < 2 {
+			t.Errorf("expected an animation, got %d frames", len(resized.Image))
+		}
+
+		// Dropped frames add their time to the ones kept
+		var total int
+		for _, delay := range resized.Delay {
+			total += delay
+		}
+		if total != 120 {
+			t.Errorf("expected the animation to last 120, got %d", total)
+		}
+		if math.Abs(float64(resized.Config.Width)/float64(resized.Config.Height)-1.5) > 0.02 {
+			t.Errorf("expected a 3:2 animation, got %dx%d", resized.Config.Width, resized.Config.Height)
+		}
+	}
+}
+
+// Verify that partial frames are drawn over the frames before them
+func TestRenderGIF(t *testing.T) {
+	g, err := gif.DecodeAll(bytes.NewReader(noiseGIF(40, 40, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frames []*image.RGBA
+	renderGIF(g, func(n int, frame *image.RGBA) {
+		frames = append(frames, cloneRGBA(frame))
+	})
+	if len(frames) != 2 || frames[1].Bounds() != image.Rect(0, 0, 40, 40) {
+		t.Fatalf("expected full frames, got %v", frames[1].Bounds())
+	}
+	if frames[1].At(30, 30) != frames[0].At(30, 30) {
+		t.Error("expected the first frame to show outside the second")
+	}
+	if frames[1].At(5, 5) != g.Image[1].At(5, 5) {
+		t.Error("expected the second frame to be drawn in its corner")
+	}
+}
@@ -0,0 +1,173 @@
+package apod
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKeyCooldown is how long a key is taken out of rotation after NASA rejects it
+const DefaultKeyCooldown = 15 * time.Minute
+
+// ParseKeys splits a list of API keys separated by commas and/or spaces, as
+// found in APOD_TOKENS
+func ParseKeys(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// apiKey is a single NASA API key and the state needed to rotate it
+type apiKey struct {
+	key    string
+	limits rateLimiter
+	// benchedUntil is when the key may be used again after a 429 or 403
+	benchedUntil time.Time
+}
+
+// keyPool spreads requests across several NASA API keys, skipping keys that
+// were recently rejected
+type keyPool struct {
+	sync.Mutex
+	keys     []*apiKey
+	next     int
+	cooldown time.Duration
+	// rejected is called when a key is taken out of rotation for being
+	// unauthorized, nil to disable
+	rejected func(KeyStatus)
+}
+
+// add puts keys into rotation, ignoring empty and duplicate keys
+func (p *keyPool) add(keys ...string) {
+	p.Lock()
+	defer p.Unlock()
+
+outer:
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		for _, existing := range p.keys {
+			if existing.key == key {
+				continue outer
+			}
+		}
+		p.keys = append(p.keys, &apiKey{key: key})
+	}
+}
+
+// pick returns the next key in rotation
+//
+// If every key is benched the one that comes back soonest is used
+func (p *keyPool) pick() *apiKey {
+	p.Lock()
+	defer p.Unlock()
+
+	if len(p.keys) == 0 {
+		return &apiKey{}
+	}
+
+	now := time.Now()
+	soonest := p.keys[p.next%len(p.keys)]
+	for i := 0; i < len(p.keys); i++ {
+		key := p.keys[(p.next+i)%len(p.keys)]
+		if !now.Before(key.benchedUntil) {
+			p.next = (p.next + i + 1) % len(p.keys)
+			return key
+		}
+		if key.benchedUntil.Before(soonest.benchedUntil) {
+			soonest = key
+		}
+	}
+	return soonest
+}
+
+// report updates a key's state from the response it received
+func (p *keyPool) report(key *apiKey, resp *http.Response) {
+	key.limits.update(resp.Header)
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusUnauthorized, http.StatusForbidden:
+		cooldown := p.cooldown
+		if after := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); after > cooldown {
+			cooldown = after
+		}
+
+		p.Lock()
+		benched := time.Now().Before(key.benchedUntil)
+		key.benchedUntil = time.Now().Add(cooldown)
+		status := KeyStatus{Key: maskKey(key.key), RateLimit: key.limits.get(), BenchedUntil: key.benchedUntil}
+		rejected := p.rejected
+		p.Unlock()
+
+		// Only the request that benches the key reports it, once per cooldown
+		if rejected != nil && !benched && resp.StatusCode != http.StatusTooManyRequests {
+			rejected(status)
+		}
+	}
+}
+
+// total sums the budgets of every key that has reported one in the last hour
+func (p *keyPool) total() RateLimit {
+	p.Lock()
+	keys := append([]*apiKey(nil), p.keys...)
+	p.Unlock()
+
+	var total RateLimit
+	for _, key := range keys {
+		limit := key.limits.get()
+		if !limit.Known() {
+			continue
+		}
+		total.Limit += limit.Limit
+		total.Remaining += limit.Remaining
+		if limit.Updated.After(total.Updated) {
+			total.Updated = limit.Updated
+		}
+	}
+	return total
+}
+
+// KeyStatus describes a single NASA API key in the client's pool
+type KeyStatus struct {
+	// Key is the API key with all but its last 4 characters masked
+	Key string
+	// RateLimit is the key's budget as of its most recent response
+	RateLimit RateLimit
+	// BenchedUntil is when the key returns to rotation, zero if it is in rotation
+	BenchedUntil time.Time
+}
+
+func (k KeyStatus) String() string {
+	s := fmt.Sprintf("%s: %s", k.Key, k.RateLimit)
+	if time.Now().Before(k.BenchedUntil) {
+		s += fmt.Sprintf(", out of rotation for %s", time.Until(k.BenchedUntil).Round(time.Second))
+	}
+	return s
+}
+
+// status describes every key in the pool
+func (p *keyPool) status() []KeyStatus {
+	p.Lock()
+	defer p.Unlock()
+
+	status := make([]KeyStatus, 0, len(p.keys))
+	for _, key := range p.keys {
+		status = append(status, KeyStatus{
+			Key:          maskKey(key.key),
+			RateLimit:    key.limits.get(),
+			BenchedUntil: key.benchedUntil,
+		})
+	}
+	return status
+}
+
+// maskKey hides all but the last 4 characters of an API key
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return key
+	}
+	return fmt.Sprintf("…%s", key[len(key)-4:])
+}
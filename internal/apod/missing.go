@@ -0,0 +1,57 @@
+package apod
+
+import (
+	"time"
+)
+
+const (
+	// recentMissing is how far back a date is considered recent, NASA sometimes
+	// publishes late or fixes a broken entry within this window
+	recentMissing = 30 * 24 * time.Hour
+	// recentMissingRecheck is how long a recent date stays known missing
+	recentMissingRecheck = 24 * time.Hour
+	// oldMissingRecheck is how long an older date stays known missing
+	oldMissingRecheck = 90 * 24 * time.Hour
+)
+
+// MissingDate records a date that the NASA API has no APOD for
+type MissingDate struct {
+	Date    string    `json:"date"`
+	Checked time.Time `json:"checked"`
+}
+
+// GetDate is required to implement the cache package's `HasDate` interface
+func (m *MissingDate) GetDate() string {
+	return m.Date
+}
+
+// isKnownMissing checks if date was recently found to have no APOD, and can be
+// skipped without asking NASA again
+func (a *APOD) isKnownMissing(date string) bool {
+	missing, ok := a.missing.Get(date)
+	if !ok {
+		return false
+	}
+
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return false
+	}
+
+	now := a.now()
+	recheck := oldMissingRecheck
+	if now.Sub(d) < recentMissing {
+		recheck = recentMissingRecheck
+	}
+	return now.Sub(missing.Checked) < recheck
+}
+
+// markMissing records that date has no APOD
+func (a *APOD) markMissing(date string) {
+	a.missing.Add(date, &MissingDate{Date: date, Checked: a.now()})
+}
+
+// isKnown checks if date is either cached or known to be missing
+func (a *APOD) isKnown(date string) bool {
+	return a.cache.Has(date) || a.isKnownMissing(date)
+}
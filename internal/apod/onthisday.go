@@ -0,0 +1,48 @@
+package apod
+
+import (
+	"time"
+)
+
+// YearsAgo returns the date the given number of years before date
+// (yyyy-mm-dd). It returns false when that day doesn't exist, like February 29
+// in a common year, or is before the first APOD
+func YearsAgo(date string, years int) (string, bool) {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return "", false
+	}
+
+	ago := time.Date(d.Year()-years, d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+	if ago.Day() != d.Day() || ago.Before(FirstDate) {
+		return "", false
+	}
+	return ago.Format("2006-01-02"), true
+}
+
+// OnThisDay returns the cached APODs from the same month and day as date
+// (yyyy-mm-dd) in every year up to NASA's today, oldest first
+func (a *APOD) OnThisDay(date string) ([]*Response, error) {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, &DateError{Date: date, Err: ErrorDateInvalid}
+	}
+
+	today := a.TodayDate()
+	var responses []*Response
+	for year := FirstDate.Year(); ; year++ {
+		day := time.Date(year, d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+		if day.Format("2006-01-02") > today {
+			break
+		}
+		// February 29 only exists in leap years
+		if day.Day() != d.Day() {
+			continue
+		}
+
+		if response, ok := a.cache.Get(day.Format("2006-01-02")); ok {
+			responses = append(responses, response)
+		}
+	}
+	return responses, nil
+}
@@ -0,0 +1,59 @@
+package apod
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+// Verify that the same day is found in every year, leap days included
+func TestOnThisDay(t *testing.T) {
+	cached := `{"date":"1995-07-01"}
+{"date":"2011-07-01"}
+{"date":"2020-07-01"}
+{"date":"2020-07-02"}
+{"date":"2021-07-01"}
+{"date":"2012-02-29"}
+{"date":"2016-02-29"}
+`
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(cached), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apod := NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](), WithFallback(nil))
+	apod.now = func() time.Time { return time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC) }
+
+	dates := func(responses []*Response) string {
+		var dates []string
+		for _, response := range responses {
+			dates = append(dates, response.Date)
+		}
+		return strings.Join(dates, " ")
+	}
+
+	responses, err := apod.OnThisDay("2021-07-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dates(responses); got != "1995-07-01 2011-07-01 2020-07-01 2021-07-01" {
+		t.Errorf("expected every July 1, got %s", got)
+	}
+
+	responses, _ = apod.OnThisDay("2020-02-29")
+	if got := dates(responses); got != "2012-02-29 2016-02-29" {
+		t.Errorf("expected only leap days, got %s", got)
+	}
+
+	if ago, ok := YearsAgo("2021-07-01", 10); !ok || ago != "2011-07-01" {
+		t.Errorf("expected 2011-07-01, got %s", ago)
+	}
+	if _, ok := YearsAgo("2020-02-29", 1); ok {
+		t.Error("expected no February 29 in 2019")
+	}
+	if _, ok := YearsAgo("2000-01-01", 10); ok {
+		t.Error("expected nothing before the first APOD")
+	}
+}
@@ -0,0 +1,120 @@
+package apod
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+const (
+	// DefaultBaseURL is the NASA APOD API endpoint used when no other is configured
+	DefaultBaseURL = "https://api.nasa.gov/planetary/apod"
+	// DefaultRequestTimeout is how long a single API request or image download may take
+	DefaultRequestTimeout = 30 * time.Second
+)
+
+// Option configures an APOD client
+type Option func(*APOD)
+
+// WithBaseURL points the client at a different APOD API endpoint, such as a
+// mirror or a local test server
+func WithBaseURL(baseURL string) Option {
+	return func(a *APOD) {
+		a.baseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithHTTPClient sets the HTTP client used for API requests and image downloads
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *APOD) {
+		a.client = client
+	}
+}
+
+// WithTransport sets the RoundTripper used for API requests and image downloads
+func WithTransport(transport http.RoundTripper) Option {
+	return func(a *APOD) {
+		a.client = &http.Client{Transport: transport}
+	}
+}
+
+// WithRequestTimeout limits how long a single API request or image download may take
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(a *APOD) {
+		a.timeout = timeout
+	}
+}
+
+// WithFillReserve sets the fraction (0 to 1) of the hourly request budget that
+// Fill leaves untouched for interactive commands
+func WithFillReserve(reserve float64) Option {
+	return func(a *APOD) {
+		a.fillReserve = reserve
+	}
+}
+
+// WithKeys puts additional API keys into rotation
+func WithKeys(keys ...string) Option {
+	return func(a *APOD) {
+		a.keys.add(keys...)
+	}
+}
+
+// WithKeyCooldown sets how long a key is taken out of rotation after NASA
+// answers it with a 429, 401 or 403
+func WithKeyCooldown(cooldown time.Duration) Option {
+	return func(a *APOD) {
+		a.keys.cooldown = cooldown
+	}
+}
+
+// WithKeyRejected calls rejected whenever NASA takes a key out of rotation
+// for being unauthorized, at most once per key per cooldown. It is called
+// from the request that was rejected
+func WithKeyRejected(rejected func(KeyStatus)) Option {
+	return func(a *APOD) {
+		a.keys.rejected = rejected
+	}
+}
+
+// WithFallback sets the archive scraper used when the JSON API fails, nil disables the fallback
+//
+// By default apod.nasa.gov is scraped
+func WithFallback(scraper *Scraper) Option {
+	return func(a *APOD) {
+		a.fallback = scraper
+		a.hasFallback = true
+	}
+}
+
+// WithMissingCache sets the cache that records dates with no APOD, so they
+// aren't requested again on every run
+func WithMissingCache(missing cache.Cache[*MissingDate]) Option {
+	return func(a *APOD) {
+		a.missing = missing
+	}
+}
+
+// WithTagCache stores the tags of each APOD in c, so they survive a restart
+func WithTagCache(c cache.Cache[*Tags]) Option {
+	return func(a *APOD) {
+		a.tags = c
+	}
+}
+
+// WithMaxImageSize limits image downloads to maxBytes. HD images over the
+// limit fall back to the regular size image
+func WithMaxImageSize(maxBytes int64) Option {
+	return func(a *APOD) {
+		a.maxImageSize = maxBytes
+	}
+}
+
+// WithDownloadProgress calls progress as images download
+func WithDownloadProgress(progress func(DownloadProgress)) Option {
+	return func(a *APOD) {
+		a.progress = progress
+	}
+}
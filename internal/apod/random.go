@@ -0,0 +1,220 @@
+package apod
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	// maxRandomDraws is how many dates or batches Random tries before giving up
+	maxRandomDraws = 5
+	// randomBatchSize is how many random APODs are asked for at once with the
+	// count parameter, the ones not used end up in the cache for next time
+	randomBatchSize = 10
+	// randomCoverage is the share of the days a filter allows that must be
+	// cached before Random draws from the cache alone, below it cached days
+	// would come up far more often than the rest
+	randomCoverage = 0.9
+	// randomProbes is how many random cached APODs are tried against a filter
+	// before looking through every one in its window
+	randomProbes = 32
+)
+
+// RandomFilter narrows down the APODs Random may pick
+type RandomFilter struct {
+	// FromYear and ToYear bound the year of the APOD, inclusive, zero for no bound
+	FromYear, ToYear int
+	// MediaType is "image" or "video", empty for any
+	MediaType string
+	// ImagesOnly skips APODs without an image or thumbnail to download
+	ImagesOnly bool
+	// Tags must all be assigned to the APOD, see Classify
+	Tags []string
+}
+
+// Matches checks if an APOD with the given tags passes the filter
+func (f RandomFilter) Matches(r *Response, tags []string) bool {
+	if f.MediaType != "" && r.MediaType != f.MediaType {
+		return false
+	}
+	if !hasTags(tags, f.Tags) {
+		return false
+	}
+	if f.ImagesOnly && !r.HasImage() {
+		return false
+	}
+
+	d, err := time.Parse("2006-01-02", r.Date)
+	if err != nil {
+		return false
+	}
+	return (f.FromYear == 0 || d.Year() >= f.FromYear) && (f.ToYear == 0 || d.Year() <= f.ToYear)
+}
+
+// matches checks if an APOD passes filter, tagging it if it wasn't cached
+func (a *APOD) matches(filter RandomFilter, r *Response) bool {
+	tags, ok := a.Tags(r.Date)
+	if !ok && len(filter.Tags) > 0 {
+		tags = Classify(r)
+	}
+	return filter.Matches(r, tags)
+}
+
+// window returns the first and last day the filter allows, up to but not
+// including NASA's today
+func (a *APOD) window(f RandomFilter) (first, last time.Time, whole bool) {
+	first = FirstDate
+	today, _ := time.Parse("2006-01-02", a.TodayDate())
+	last = today.AddDate(0, 0, -1)
+	whole = true
+
+	if from := time.Date(f.FromYear, 1, 1, 0, 0, 0, 0, time.UTC); from.After(first) {
+		first, whole = from, false
+	}
+	if f.ToYear != 0 {
+		if to := time.Date(f.ToYear, 12, 31, 0, 0, 0, 0, time.UTC); to.Before(last) {
+			last, whole = to, false
+		}
+	}
+	return first, last, whole
+}
+
+// randomDay picks a day between first and last, inclusive, with every day
+// equally likely
+func randomDay(first, last time.Time) string {
+	days := int(last.Sub(first).Hours() / 24)
+	return first.AddDate(0, 0, rand.Intn(days+1)).Format("2006-01-02")
+}
+
+// Random gets a random APOD, see RandomWith
+func (a *APOD) Random() (*Response, error) {
+	return a.RandomContext(context.Background())
+}
+
+// RandomContext is like Random but stops waiting on the NASA API when ctx is done
+func (a *APOD) RandomContext(ctx context.Context) (*Response, error) {
+	return a.RandomWith(ctx, RandomFilter{})
+}
+
+// RandomWith gets a random APOD that passes filter. It is drawn from the cache
+// when the cache can list its contents and holds most of the days the filter
+// allows, otherwise from NASA with the cache as a fallback
+func (a *APOD) RandomWith(ctx context.Context, filter RandomFilter) (*Response, error) {
+	first, last, _ := a.window(filter)
+	if last.Before(first) {
+		return nil, ErrorNoMatch
+	}
+
+	cached, coverage, ok := a.randomCached(filter)
+	if ok && coverage >= randomCoverage {
+		return cached, nil
+	}
+
+	response, err := a.randomFromNASA(ctx, filter)
+	if err != nil && ok && ctx.Err() == nil {
+		return cached, nil
+	}
+	return response, err
+}
+
+// randomFromNASA draws a random APOD that passes filter through the API
+func (a *APOD) randomFromNASA(ctx context.Context, filter RandomFilter) (*Response, error) {
+	first, last, whole := a.window(filter)
+
+	// NASA can only pick from the whole archive, so it's only asked when the
+	// filter allows most of what it returns
+	if whole {
+		for i := 0; i < maxRandomDraws; i++ {
+			responses, err := a.RandomBatch(ctx, randomBatchSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, response := range responses {
+				if a.matches(filter, response) {
+					return response, nil
+				}
+			}
+		}
+		return nil, ErrorNoMatch
+	}
+
+	// Some days have no APOD, draw again when we land on one
+	err := ErrorNoMatch
+	for i := 0; i < maxRandomDraws; i++ {
+		var response *Response
+		response, err = a.GetContext(ctx, randomDay(first, last))
+		if err == nil && a.matches(filter, response) {
+			return response, nil
+		} else if err != nil && !errors.Is(err, ErrorDateNotFound) {
+			return nil, err
+		}
+	}
+	if err == nil {
+		err = ErrorNoMatch
+	}
+	return nil, err
+}
+
+// RandomCached picks a random APOD that passes filter from the cache, without
+// asking NASA. It returns false if nothing matches
+func (a *APOD) RandomCached(filter RandomFilter) (*Response, bool) {
+	response, _, ok := a.randomCached(filter)
+	return response, ok
+}
+
+// randomCached is like RandomCached, also returning the share of the days
+// the filter allows that are cached
+func (a *APOD) randomCached(filter RandomFilter) (*Response, float64, bool) {
+	first, last, _ := a.window(filter)
+	response, cached, ok := a.index.Random(first.Format("2006-01-02"), last.Format("2006-01-02"), filter.Matches)
+
+	days := int(last.Sub(first).Hours()/24) + 1
+	return response, float64(cached) / float64(days), ok
+}
+
+// Random picks a random indexed APOD dated between from and to, inclusive,
+// that match accepts, every match being equally likely. It also returns how
+// many APODs are indexed between from and to
+func (ix *Index) Random(from, to string, match func(*Response, []string) bool) (*Response, int, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	lo := sort.SearchStrings(ix.dates, from)
+	hi := sort.Search(len(ix.dates), func(i int) bool { return ix.dates[i] > to })
+	n := hi - lo
+	if n <= 0 {
+		return nil, 0, false
+	}
+
+	// Most filters match plenty of APODs, so a few draws find one
+	for i := 0; i < randomProbes; i++ {
+		doc := ix.docs[ix.dates[lo+rand.Intn(n)]]
+		if match(doc.response, doc.tags) {
+			return doc.response, n, true
+		}
+	}
+
+	// Rare matches are looked for one by one, keeping each with a chance that
+	// leaves them all equally likely
+	var picked *Response
+	matches := 0
+	for _, date := range ix.dates[lo:hi] {
+		doc := ix.docs[date]
+		if !match(doc.response, doc.tags) {
+			continue
+		}
+		matches++
+		if rand.Intn(matches) == 0 {
+			picked = doc.response
+		}
+	}
+	return picked, n, picked != nil
+}
+
+// RandomBatch asks NASA for count random APODs in a single request
+func (a *APOD) RandomBatch(ctx context.Context, count int) ([]*Response, error) {
+	return a.countRequest(ctx, count)
+}
@@ -0,0 +1,166 @@
+package apod
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// maxRangeDays is the most days asked for in a single range request
+	maxRangeDays = 100
+	// iteratorDays is how many days a RangeIterator fetches at a time
+	iteratorDays = 30
+)
+
+// dateRuns groups sorted dates into runs of consecutive days no longer than size
+func dateRuns(dates []string, size int) [][2]string {
+	var runs [][2]string
+	for i := 0; i < len(dates); {
+		start, _ := time.Parse("2006-01-02", dates[i])
+		j := i + 1
+		for j < len(dates) && j-i < max(size, 1) {
+			next := start.AddDate(0, 0, j-i).Format("2006-01-02")
+			if dates[j] != next {
+				break
+			}
+			j++
+		}
+		runs = append(runs, [2]string{dates[i], dates[j-1]})
+		i = j
+	}
+	return runs
+}
+
+// parseRange validates the dates of a range, clamping end to NASA's today
+func (a *APOD) parseRange(start, end string) (time.Time, time.Time, error) {
+	first, err := time.Parse("2006-01-02", start)
+	if err != nil || !IsValidDate(start) {
+		return first, first, &DateError{Date: start, Err: ErrorDateInvalid}
+	}
+	last, err := time.Parse("2006-01-02", end)
+	if err != nil || last.Before(first) {
+		return first, last, &DateError{Date: end, Err: ErrorDateInvalid}
+	}
+
+	today, _ := time.Parse("2006-01-02", a.TodayDate())
+	if last.After(today) {
+		last = today
+	}
+	return first, last, nil
+}
+
+// GetRange gets every APOD from start to end (yyyy-mm-dd), inclusive and in
+// order. Cached dates are used as is, the rest are fetched with as few range
+// requests as possible. Dates without an APOD are left out and remembered
+func (a *APOD) GetRange(ctx context.Context, start, end string) ([]*Response, error) {
+	first, last, err := a.parseRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var dates, gaps []string
+	for d := first; !d.After(last); d = d.AddDate(0, 0, 1) {
+		date := d.Format("2006-01-02")
+		dates = append(dates, date)
+		if !a.isKnown(date) {
+			gaps = append(gaps, date)
+		}
+	}
+
+	fetched := make(map[string]*Response, len(gaps))
+	for _, run := range dateRuns(gaps, maxRangeDays) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		responses, err := a.rangeRequest(ctx, run[0], run[1])
+		if err != nil {
+			return nil, err
+		}
+		for _, response := range responses {
+			fetched[response.Date] = response
+		}
+	}
+
+	// Days the API skipped have no APOD, except today's which may be late
+	today := a.TodayDate()
+	for _, date := range gaps {
+		if _, ok := fetched[date]; !ok && date != today {
+			a.markMissing(date)
+		}
+	}
+
+	responses := make([]*Response, 0, len(dates))
+	for _, date := range dates {
+		if response, ok := fetched[date]; ok {
+			responses = append(responses, response)
+		} else if response, ok := a.cache.Get(date); ok {
+			responses = append(responses, response)
+		}
+	}
+	return responses, nil
+}
+
+// RangeIterator streams the APODs of a range in order, fetching a few weeks
+// at a time
+//
+//	it := a.IterRange(ctx, "2021-01-01", "2021-12-31")
+//	for it.Next() {
+//		fmt.Println(it.Response().Title)
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type RangeIterator struct {
+	apod *APOD
+	ctx  context.Context
+
+	next, last time.Time
+	buffer     []*Response
+	current    *Response
+	err        error
+}
+
+// IterRange creates a RangeIterator over the APODs from start to end (yyyy-mm-dd), inclusive
+func (a *APOD) IterRange(ctx context.Context, start, end string) *RangeIterator {
+	first, last, err := a.parseRange(start, end)
+	return &RangeIterator{
+		apod: a,
+		ctx:  ctx,
+		next: first,
+		last: last,
+		err:  err,
+	}
+}
+
+// Next advances to the next APOD, returning false when the range is done or
+// an error occurred
+func (it *RangeIterator) Next() bool {
+	for len(it.buffer) == 0 {
+		if it.err != nil || it.next.After(it.last) {
+			it.current = nil
+			return false
+		}
+
+		end := it.next.AddDate(0, 0, iteratorDays-1)
+		if end.After(it.last) {
+			end = it.last
+		}
+
+		it.buffer, it.err = it.apod.GetRange(it.ctx, it.next.Format("2006-01-02"), end.Format("2006-01-02"))
+		it.next = end.AddDate(0, 0, 1)
+	}
+
+	it.current, it.buffer = it.buffer[0], it.buffer[1:]
+	return true
+}
+
+// Response returns the current APOD
+func (it *RangeIterator) Response() *Response {
+	return it.current
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *RangeIterator) Err() error {
+	return it.err
+}
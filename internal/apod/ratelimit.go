@@ -0,0 +1,118 @@
+package apod
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFillReserve is the fraction of the hourly budget that Fill leaves for interactive commands
+	DefaultFillReserve = 0.2
+	// fillBudgetPause is how long Fill waits for the budget to recover before checking it again
+	fillBudgetPause = 10 * time.Minute
+)
+
+// RateLimit is the NASA API request budget as reported by the most recent response
+type RateLimit struct {
+	// Limit is the number of requests allowed per hour
+	Limit int
+	// Remaining is the number of requests left in the current hour
+	Remaining int
+	// Updated is when the budget was last reported, zero if it never was
+	Updated time.Time
+}
+
+// Known reports whether the NASA API has reported a budget in the last hour
+func (r RateLimit) Known() bool {
+	return !r.Updated.IsZero() && time.Since(r.Updated) < time.Hour
+}
+
+// Low reports whether less than reserve (a fraction of Limit) of the budget remains
+func (r RateLimit) Low(reserve float64) bool {
+	return r.Known() && float64(r.Remaining) < reserve*float64(r.Limit)
+}
+
+func (r RateLimit) String() string {
+	if r.Updated.IsZero() {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d/%d requests remaining (as of %s ago)", r.Remaining, r.Limit, time.Since(r.Updated).Round(time.Second))
+}
+
+// rateLimiter tracks the request budget reported by the NASA API
+type rateLimiter struct {
+	sync.Mutex
+	state RateLimit
+}
+
+// parseRateLimit reads the budget from the X-RateLimit headers of a response
+func parseRateLimit(header http.Header) (RateLimit, bool) {
+	limit, err := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return RateLimit{}, false
+	}
+	return RateLimit{Limit: limit, Remaining: remaining, Updated: time.Now()}, true
+}
+
+// update records the budget from the X-RateLimit headers of a response, if present
+func (r *rateLimiter) update(header http.Header) {
+	limit, ok := parseRateLimit(header)
+	if !ok {
+		return
+	}
+
+	r.Lock()
+	r.state = limit
+	r.Unlock()
+}
+
+// get returns the most recently reported budget
+func (r *rateLimiter) get() RateLimit {
+	r.Lock()
+	defer r.Unlock()
+	return r.state
+}
+
+// RateLimit returns the NASA API request budget summed across every key, as of
+// each key's most recent response
+func (a *APOD) RateLimit() RateLimit {
+	return a.keys.total()
+}
+
+// Keys describes every API key in rotation
+func (a *APOD) Keys() []KeyStatus {
+	return a.keys.status()
+}
+
+// waitForBudget blocks background work while the request budget is low, so
+// that interactive commands keep working
+//
+// Once fillBudgetPause has passed without a fresh report a single request is let
+// through to refresh the budget
+func (a *APOD) waitForBudget(ctx context.Context) error {
+	for {
+		limit := a.RateLimit()
+		if !limit.Low(a.fillReserve) {
+			return nil
+		}
+
+		wait := fillBudgetPause - time.Since(limit.Updated)
+		if wait <= 0 {
+			return nil
+		}
+
+		log.Println("NASA API budget is low,", limit, "- pausing background requests for", wait.Round(time.Second))
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package apod
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// relatedTagWeight is how much sharing every tag adds to the similarity
+	// of two explanations, which is between 0 and 1
+	relatedTagWeight = 0.25
+	// relatedCommonTerms skips terms found in more than this share of APODs,
+	// they say little about the topic and are slow to compare
+	relatedCommonTerms = 0.5
+)
+
+// termWeight dampens repeated terms, so one word can't dominate an explanation
+func termWeight(count int) float64 {
+	return 1 + math.Log(float64(count))
+}
+
+// Related returns up to n APODs most like the one on date, most similar first.
+// Similarity is the cosine of the TF-IDF vectors of the explanations, plus a
+// bonus for shared tags
+func (ix *Index) Related(date string, n int) []Result {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	doc, ok := ix.docs[date]
+	if !ok {
+		return nil
+	}
+
+	total := float64(len(ix.docs))
+	scores := make(map[string]float64)
+
+	// The dot product of the explanations, the idf appears once for each side
+	if doc.norm > 0 {
+		for _, term := range doc.terms {
+			p := ix.postings[term][date]
+			if strings.HasPrefix(term, tagPrefix) || p.explanation == 0 {
+				continue
+			}
+
+			df := float64(len(ix.postings[term]))
+			if df > total*relatedCommonTerms {
+				continue
+			}
+			idf := math.Log(total / df)
+			weight := termWeight(p.explanation) * idf * idf / doc.norm
+
+			for other, q := range ix.postings[term] {
+				if other != date && q.explanation > 0 {
+					scores[other] += weight * termWeight(q.explanation) / ix.docs[other].norm
+				}
+			}
+		}
+	}
+
+	// Shared tags, as a share of the tags either APOD has
+	if len(doc.tags) > 0 {
+		shared := make(map[string]int)
+		for _, tag := range doc.tags {
+			for other := range ix.postings[tagPrefix+tag] {
+				if other != date {
+					shared[other]++
+				}
+			}
+		}
+		for other, count := range shared {
+			union := len(doc.tags) + len(ix.docs[other].tags) - count
+			scores[other] += relatedTagWeight * float64(count) / float64(union)
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for other, score := range scores {
+		results = append(results, Result{Response: ix.docs[other].response, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Response.Date > results[j].Response.Date
+	})
+
+	if n > 0 && len(results) > n {
+		results = results[:n]
+	}
+	return results
+}
+
+// Related returns up to n cached APODs most like the one on date, see Index.Related
+func (a *APOD) Related(date string, n int) []Result {
+	return a.index.Related(date, n)
+}
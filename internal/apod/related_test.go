@@ -0,0 +1,47 @@
+package apod
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+// Verify that related APODs share words and tags
+func TestRelated(t *testing.T) {
+	cached := `{"date":"2020-01-01","title":"The Eagle Nebula","explanation":"Towering pillars of cold gas and dust rise inside the Eagle Nebula, where new stars form."}
+{"date":"2020-01-02","title":"Pillars of Creation in Infrared","explanation":"Infrared light reveals the stars hidden inside the dusty pillars of the Eagle."}
+{"date":"2020-01-03","title":"Aurora over Iceland","explanation":"Green curtains of aurora dance over a frozen waterfall in Iceland."}
+{"date":"2020-01-04","title":"Aurora over Norway","explanation":"Green aurora curtains shimmer above snowy mountains in Norway."}
+{"date":"2020-01-05","title":"Perseverance on Mars","explanation":"The rover rolls across Jezero crater on Mars."}
+{"date":"2020-01-06","title":"Saturn's Rings","explanation":"Cassini looks back at the rings of Saturn."}
+`
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(cached), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apod := NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](), WithFallback(nil))
+
+	tests := map[string]string{
+		"2020-01-01": "2020-01-02",
+		"2020-01-02": "2020-01-01",
+		"2020-01-03": "2020-01-04",
+		"2020-01-04": "2020-01-03",
+	}
+	for date, expected := range tests {
+		related := apod.Related(date, 2)
+		if len(related) == 0 || related[0].Response.Date != expected {
+			t.Errorf("%s: expected %s first, got %q", date, expected, resultDates(related))
+		}
+		for _, result := range related {
+			if result.Response.Date == date {
+				t.Errorf("%s: an APOD is not related to itself", date)
+			}
+		}
+	}
+
+	if related := apod.Related("1999-01-01", 2); related != nil {
+		t.Errorf("expected nothing for an uncached date, got %q", resultDates(related))
+	}
+}
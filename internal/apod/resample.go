@@ -0,0 +1,133 @@
+package apod
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// resampleWeights are the filter taps of one output pixel
+type resampleWeights struct {
+	start   int
+	weights []float64
+}
+
+// catmullRom is the Catmull-Rom cubic, a sharp filter with support [-2, 2]
+func catmullRom(x float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return (1.5*x-2.5)*x*x + 1
+	case x < 2:
+		return ((-0.5*x+2.5)*x-4)*x + 2
+	}
+	return 0
+}
+
+// filterWeights computes the taps mapping src pixels onto dst pixels along one
+// axis. When shrinking, the filter is widened so every source pixel contributes
+func filterWeights(dst, src int) []resampleWeights {
+	ratio := float64(src) / float64(dst)
+	stretch := max(ratio, 1)
+	support := 2 * stretch
+
+	// Every pixel's weights share one allocation
+	taps := make([]resampleWeights, dst)
+	all := make([]float64, 0, dst*(2*int(math.Ceil(support))+1))
+	for x := range taps {
+		center := (float64(x)+0.5)*ratio - 0.5
+		start := max(int(math.Ceil(center-support)), 0)
+		end := min(int(math.Floor(center+support)), src-1)
+
+		var sum float64
+		first := len(all)
+		for i := start; i <= end; i++ {
+			w := catmullRom((float64(i) - center) / stretch)
+			all = append(all, w)
+			sum += w
+		}
+		weights := all[first:len(all):len(all)]
+		for i := range weights {
+			weights[i] /= sum
+		}
+		taps[x] = resampleWeights{start: start, weights: weights}
+	}
+	return taps
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if needed
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	b := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(rgba, rgba.Rect, img, b.Min, draw.Src)
+	return rgba
+}
+
+// scaleImage resamples img to w×h pixels with a Catmull-Rom filter, one axis at a time
+func scaleImage(img image.Image, w, h int) *image.RGBA {
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	// Horizontal pass, sw×sh to w×sh
+	tmp := image.NewRGBA(image.Rect(0, 0, w, sh))
+	xTaps := filterWeights(w, sw)
+	for y := 0; y < sh; y++ {
+		row := src.Pix[y*src.Stride:]
+		out := tmp.Pix[y*tmp.Stride:]
+		for x, tap := range xTaps {
+			var r, g, b, a float64
+			for i, weight := range tap.weights {
+				p := row[(tap.start+i)*4:]
+				r += float64(p[0]) * weight
+				g += float64(p[1]) * weight
+				b += float64(p[2]) * weight
+				a += float64(p[3]) * weight
+			}
+			setPixel(out[x*4:], r, g, b, a)
+		}
+	}
+
+	// Vertical pass, w×sh to w×h
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	yTaps := filterWeights(h, sh)
+	for y, tap := range yTaps {
+		out := dst.Pix[y*dst.Stride:]
+		for x := 0; x < w; x++ {
+			var r, g, b, a float64
+			for i, weight := range tap.weights {
+				p := tmp.Pix[(tap.start+i)*tmp.Stride+x*4:]
+				r += float64(p[0]) * weight
+				g += float64(p[1]) * weight
+				b += float64(p[2]) * weight
+				a += float64(p[3]) * weight
+			}
+			setPixel(out[x*4:], r, g, b, a)
+		}
+	}
+
+	return dst
+}
+
+// setPixel stores a filtered pixel, clamping the overshoot of the filter's
+// negative lobes. Colors stay premultiplied, so they can't exceed alpha
+func setPixel(p []byte, r, g, b, a float64) {
+	alpha := clamp8(a)
+	p[0] = min(clamp8(r), alpha)
+	p[1] = min(clamp8(g), alpha)
+	p[2] = min(clamp8(b), alpha)
+	p[3] = alpha
+}
+
+// clamp8 rounds v to the nearest byte
+func clamp8(v float64) uint8 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
@@ -1,6 +1,8 @@
 package apod
 
 import (
+	"context"
+	"errors"
 	"fmt"
 )
 
@@ -28,10 +30,33 @@ func (a *Response) CreateExplanation() string {
 
 // DownloadRawImage downloads the image without resizing
 func (a *Response) DownloadRawImage() (*ImageWrapper, error) {
+	return a.downloadRawImage(context.Background(), defaultDownloader)
+}
+
+// HasImage checks if the APOD has an image or a thumbnail to download
+func (a *Response) HasImage() bool {
 	if a.MediaType == "image" {
-		return downloadImage(a.HdURL)
+		return a.HdURL != "" || a.URL != ""
+	}
+	return a.Thumbnail != ""
+}
+
+// downloadRawImage downloads the image without resizing. The HD image is
+// preferred, falling back to the regular one when it's missing or too large
+func (a *Response) downloadRawImage(ctx context.Context, d downloader) (*ImageWrapper, error) {
+	if a.MediaType != "image" {
+		return d.download(ctx, a.Thumbnail)
+	}
+	if a.HdURL == "" {
+		return d.download(ctx, a.URL)
+	}
+
+	image, err := d.download(ctx, a.HdURL)
+	var dlErr *DownloadError
+	if err != nil && a.URL != "" && a.URL != a.HdURL && errors.As(err, &dlErr) && dlErr.missing() {
+		return d.download(ctx, a.URL)
 	}
-	return downloadImage(a.Thumbnail)
+	return image, err
 }
 
 // GetDate is required to implement the cache package's `HasDate` interface
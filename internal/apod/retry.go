@@ -0,0 +1,163 @@
+package apod
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy controls how often and how long Retry reruns a failing function
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times the function is called
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failure
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between any two attempts
+	MaxBackoff time.Duration
+	// Multiplier grows the wait after every failure
+	Multiplier float64
+	// Jitter is the fraction (0 to 1) of each wait that is randomized
+	Jitter float64
+	// MaxElapsed stops retrying once the next attempt would start after this much time
+	MaxElapsed time.Duration
+}
+
+var (
+	// InteractiveRetry is used by slash commands, where a user is waiting on the answer
+	InteractiveRetry = RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 250 * time.Millisecond,
+		MaxBackoff:     2 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.5,
+		MaxElapsed:     10 * time.Second,
+	}
+	// SchedulerRetry is used by the scheduler, which has the better part of an hour to deliver
+	SchedulerRetry = RetryPolicy{
+		MaxAttempts:    8,
+		InitialBackoff: 5 * time.Second,
+		MaxBackoff:     2 * time.Minute,
+		Multiplier:     2,
+		Jitter:         0.5,
+		MaxElapsed:     10 * time.Minute,
+	}
+	// FillRetry is used while filling the cache in the background
+	FillRetry = RetryPolicy{
+		MaxAttempts:    5,
+		InitialBackoff: 10 * time.Second,
+		MaxBackoff:     5 * time.Minute,
+		Multiplier:     3,
+		Jitter:         0.5,
+		MaxElapsed:     30 * time.Minute,
+	}
+)
+
+// backoff returns the jittered wait after the given (zero based) failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := float64(p.InitialBackoff)
+	for i := 0; i < attempt; i++ {
+		wait *= p.Multiplier
+		if p.MaxBackoff > 0 && wait > float64(p.MaxBackoff) {
+			wait = float64(p.MaxBackoff)
+			break
+		}
+	}
+
+	// Randomize part of the wait so that concurrent callers spread out
+	wait -= wait * p.Jitter * rand.Float64()
+	return time.Duration(wait)
+}
+
+// IsRetryable reports whether an error returned by the client may go away on its own
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, ErrorDateInvalid) || errors.Is(err, ErrorDateNotFound) || errors.Is(err, ErrorPageFormat) || errors.Is(err, ErrorNoMatch) {
+		return false
+	}
+
+	// A single attempt timing out is transient, Retry itself stops once the
+	// caller's ctx is done
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Retryable()
+	}
+
+	var archiveErr *ArchiveError
+	if errors.As(err, &archiveErr) {
+		return archiveErr.Retryable()
+	}
+
+	var dlErr *DownloadError
+	if errors.As(err, &dlErr) {
+		return dlErr.Retryable()
+	}
+
+	// Network and decoding errors are usually transient
+	return true
+}
+
+// retryAfter returns how long the NASA API asked us to wait, if it did so with a 429 or 503
+func retryAfter(err error) time.Duration {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return 0
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return apiErr.RetryAfter
+	}
+	return 0
+}
+
+// Retry reruns f with exponential backoff until it succeeds, fails with an
+// error that can't be retried, or the policy gives up
+func Retry[T any](ctx context.Context, policy RetryPolicy, f func(context.Context) (T, error)) (res T, err error) {
+	start := time.Now()
+	attempts := max(policy.MaxAttempts, 1)
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		res, err = f(ctx)
+		if err == nil || !IsRetryable(err) || ctx.Err() != nil || attempt+1 == attempts {
+			return res, err
+		}
+
+		wait := policy.backoff(attempt)
+		if after := retryAfter(err); after > wait {
+			wait = after
+		}
+
+		// Give up if waiting would take us past the time budget
+		if policy.MaxElapsed > 0 && time.Since(start)+wait > policy.MaxElapsed {
+			return res, err
+		}
+
+		if sleep(ctx, wait) != nil {
+			return res, err
+		}
+	}
+
+	return res, err
+}
+
+// sleep pauses for d, returning early with ctx's error if ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
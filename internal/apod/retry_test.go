@@ -0,0 +1,124 @@
+package apod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testRetry = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     5 * time.Millisecond,
+	Multiplier:     2,
+	Jitter:         0.5,
+	MaxElapsed:     time.Second,
+}
+
+// Verify that transient errors are retried until they succeed
+func TestRetryTransient(t *testing.T) {
+	calls := 0
+	res, err := Retry(context.Background(), testRetry, func(context.Context) (int, error) {
+		calls++
+		if calls < 3 {
+			return 0, &APIError{StatusCode: http.StatusBadGateway, Status: "502 Bad Gateway"}
+		}
+		return 42, nil
+	})
+
+	if err != nil || res != 42 {
+		t.Errorf("expected 42, got %d %v", res, err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 calls, got %d", calls)
+	}
+}
+
+// Verify that permanent errors are not retried
+func TestRetryPermanent(t *testing.T) {
+	for _, permanent := range []error{
+		ErrorDateInvalid,
+		ErrorDateNotFound,
+		&APIError{StatusCode: http.StatusForbidden, Status: "403 Forbidden"},
+	} {
+		calls := 0
+		_, err := Retry(context.Background(), testRetry, func(context.Context) (int, error) {
+			calls++
+			return 0, permanent
+		})
+
+		if err != permanent {
+			t.Errorf("expected %v, got %v", permanent, err)
+		}
+		if calls != 1 {
+			t.Errorf("%v: expected 1 call, got %d", permanent, calls)
+		}
+	}
+}
+
+// Verify that per-attempt timeouts are retried, but only while the caller's ctx is live
+func TestRetryTimeout(t *testing.T) {
+	calls := 0
+	res, err := Retry(context.Background(), testRetry, func(context.Context) (int, error) {
+		calls++
+		if calls < 2 {
+			return 0, fmt.Errorf("get: %w", context.DeadlineExceeded)
+		}
+		return 42, nil
+	})
+	if err != nil || res != 42 || calls != 2 {
+		t.Errorf("expected 42 after 2 calls, got %d %v after %d", res, err, calls)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	calls = 0
+	_, err = Retry(ctx, testRetry, func(ctx context.Context) (int, error) {
+		calls++
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) || calls != 1 {
+		t.Errorf("expected to stop after 1 call with the caller's deadline, got %v after %d", err, calls)
+	}
+}
+
+// Verify that Retry-After on a 429 is honoured, and that it counts against MaxElapsed
+func TestRetryAfter(t *testing.T) {
+	calls := 0
+	start := time.Now()
+	_, err := Retry(context.Background(), testRetry, func(context.Context) (int, error) {
+		calls++
+		return 0, &APIError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests", RetryAfter: 2 * time.Second}
+	})
+
+	if err == nil {
+		t.Error("expected an error")
+	}
+	if calls != 1 {
+		t.Errorf("expected to give up after 1 call, got %d", calls)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("waited past MaxElapsed")
+	}
+}
+
+// Verify that the Retry-After header is parsed in both of its forms
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if d := parseRetryAfter("120", now); d != 2*time.Minute {
+		t.Errorf("expected 2m, got %v", d)
+	}
+
+	if d := parseRetryAfter(now.Add(time.Minute).Format(http.TimeFormat), now); d != time.Minute {
+		t.Errorf("expected 1m, got %v", d)
+	}
+
+	if d := parseRetryAfter("soon", now); d != 0 {
+		t.Errorf("expected 0, got %v", d)
+	}
+}
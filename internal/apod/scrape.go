@@ -0,0 +1,199 @@
+package apod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultArchiveURL is the apod.nasa.gov archive that Scraper reads when no other is configured
+const DefaultArchiveURL = "https://apod.nasa.gov/apod/"
+
+// scrapedService is the Service of responses read from the archive. They lack
+// some of the API's fields, so they are not cached
+const scrapedService = "html"
+
+// ErrorArchiveUnavailable matches ArchiveErrors for pages that failed on NASA's side
+var ErrorArchiveUnavailable = errors.New("apod.nasa.gov unavailable")
+
+// ArchiveError is returned when apod.nasa.gov responds with an unexpected status code
+type ArchiveError struct {
+	// Page is the archive page that was requested, e.g. "ap210701.html"
+	Page string
+	// StatusCode is the HTTP status code of the response
+	StatusCode int
+	// Status is the HTTP status line of the response, e.g. "503 Service Unavailable"
+	Status string
+}
+
+func (e *ArchiveError) Error() string {
+	return fmt.Sprintf("apod.nasa.gov failure for %s: %s", e.Page, e.Status)
+}
+
+// Retryable reports whether the page may load if it is tried again
+func (e *ArchiveError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusRequestTimeout || e.StatusCode >= 500
+}
+
+// Is lets errors.Is match an ArchiveError against ErrorArchiveUnavailable
+func (e *ArchiveError) Is(target error) bool {
+	return target == ErrorArchiveUnavailable && e.StatusCode >= 500
+}
+
+// Scraper reads APOD entries from the static apYYMMDD.html pages on
+// apod.nasa.gov, which usually stay up when the JSON API is down
+type Scraper struct {
+	baseURL *url.URL
+	client  *http.Client
+}
+
+// NewScraper creates a new Scraper for the archive at baseURL
+func NewScraper(baseURL string, client *http.Client) (*Scraper, error) {
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Scraper{baseURL: u, client: client}, nil
+}
+
+// Get the APOD for a specific date (yyyy-mm-dd)
+func (s *Scraper) Get(ctx context.Context, date string) (*Response, error) {
+	d, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return nil, &DateError{Date: date, Err: ErrorDateInvalid}
+	}
+
+	return s.scrape(ctx, d.Format("ap060102.html"))
+}
+
+// Today gets the APOD currently on the front page
+func (s *Scraper) Today(ctx context.Context) (*Response, error) {
+	return s.scrape(ctx, "astropix.html")
+}
+
+// scrape downloads and parses a single page of the archive
+func (s *Scraper) scrape(ctx context.Context, page string) (*Response, error) {
+	pageURL := s.baseURL.ResolveReference(&url.URL{Path: page})
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrorDateNotFound
+	} else if resp.StatusCode != http.StatusOK {
+		return nil, &ArchiveError{Page: page, StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParsePage(pageURL, string(body))
+}
+
+var (
+	// "<title> APOD: 2021 July 1 - Perseverance Selfie with Ingenuity </title>"
+	titleRegex = regexp.MustCompile(`(?is)<title>\s*APOD:\s*(\d{4}\s+[a-z]+\s+\d{1,2})\s*-\s*(.*?)\s*</title>`)
+	// <a href="hd.jpg"> <IMG SRC="small.jpg" ...>
+	linkedImageRegex = regexp.MustCompile(`(?is)<a\s+href="([^"]+)"\s*>\s*<img\s+[^>]*?src="([^"]+)"`)
+	imageRegex       = regexp.MustCompile(`(?is)<img\s+[^>]*?src="([^"]+)"`)
+	iframeRegex      = regexp.MustCompile(`(?is)<iframe\s+[^>]*?src="([^"]+)"`)
+	// <b> Image Credit & Copyright: </b> names </center>
+	creditRegex = regexp.MustCompile(`(?is)<b>([^<]*credit.*?)</b>(.*?)</center>`)
+	// <b> Explanation: </b> text <p> <center>
+	explanationRegex = regexp.MustCompile(`(?is)<b>\s*Explanation:\s*</b>(.*?)<p>\s*<center>`)
+	youtubeRegex     = regexp.MustCompile(`youtube(?:-nocookie)?\.com/embed/([A-Za-z0-9_-]+)`)
+	tagRegex         = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRegex       = regexp.MustCompile(`\s+`)
+)
+
+// ErrorPageFormat is returned when an archive page doesn't look like an APOD page
+var ErrorPageFormat = errors.New("apod.nasa.gov page is not in the expected format")
+
+// ParsePage parses the HTML of an apod.nasa.gov page into a Response. Links
+// are resolved against pageURL
+func ParsePage(pageURL *url.URL, page string) (*Response, error) {
+	title := titleRegex.FindStringSubmatch(page)
+	if title == nil {
+		return nil, ErrorPageFormat
+	}
+
+	date, err := time.Parse("2006 January 2", spaceRegex.ReplaceAllString(title[1], " "))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrorPageFormat, err)
+	}
+
+	response := &Response{
+		Title:   cleanText(title[2]),
+		Date:    date.Format("2006-01-02"),
+		Service: scrapedService,
+	}
+
+	resolve := func(link string) string {
+		u, err := pageURL.Parse(html.UnescapeString(strings.TrimSpace(link)))
+		if err != nil {
+			return link
+		}
+		return u.String()
+	}
+
+	// Videos are embedded, pictures are linked to their HD version
+	if match := iframeRegex.FindStringSubmatch(page); match != nil {
+		response.MediaType = "video"
+		response.URL = resolve(match[1])
+		if id := youtubeRegex.FindStringSubmatch(response.URL); id != nil {
+			response.Thumbnail = fmt.Sprintf("https://img.youtube.com/vi/%s/0.jpg", id[1])
+		}
+	} else if match := linkedImageRegex.FindStringSubmatch(page); match != nil {
+		response.MediaType = "image"
+		response.HdURL = resolve(match[1])
+		response.URL = resolve(match[2])
+	} else if match := imageRegex.FindStringSubmatch(page); match != nil {
+		response.MediaType = "image"
+		response.URL = resolve(match[1])
+		response.HdURL = response.URL
+	} else {
+		response.MediaType = "other"
+	}
+
+	// Like the API, only copyrighted work carries a copyright
+	if match := creditRegex.FindStringSubmatch(page); match != nil {
+		if strings.Contains(strings.ToLower(match[1]), "copyright") {
+			response.Copyright = cleanText(match[2])
+		}
+	}
+
+	if match := explanationRegex.FindStringSubmatch(page); match != nil {
+		response.Explanation = cleanText(match[1])
+	}
+
+	return response, nil
+}
+
+// cleanText strips tags and entities from a fragment of HTML and collapses whitespace
+func cleanText(fragment string) string {
+	text := tagRegex.ReplaceAllString(fragment, "")
+	text = html.UnescapeString(text)
+	return strings.TrimSpace(spaceRegex.ReplaceAllString(text, " "))
+}
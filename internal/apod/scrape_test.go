@@ -0,0 +1,259 @@
+package apod
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+// newArchiveServer creates a stand-in for apod.nasa.gov serving the pages in
+// testdata/html, with astropix.html showing 2021-07-01
+func newArchiveServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		page := filepath.Base(r.URL.Path)
+		if page == "astropix.html" {
+			page = "ap210701.html"
+		}
+
+		data, err := os.ReadFile(filepath.Join("testdata", "html", page))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func parseFixture(t *testing.T, page string) *Response {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", "html", page))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pageURL, _ := url.Parse("https://apod.nasa.gov/apod/" + page)
+	resp, err := ParsePage(pageURL, string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// Verify a public domain image page
+func TestParsePageImage(t *testing.T) {
+	resp := parseFixture(t, "ap210701.html")
+
+	if resp.Title != "Perseverance Selfie with Ingenuity" {
+		t.Errorf("Incorrect title %q", resp.Title)
+	}
+
+	if resp.Date != "2021-07-01" {
+		t.Errorf("Incorrect date %q", resp.Date)
+	}
+
+	if resp.MediaType != "image" {
+		t.Errorf("Incorrect media type %q", resp.MediaType)
+	}
+
+	if resp.URL != "https://apod.nasa.gov/apod/image/2107/PIA24542_fig2_1100c.jpg" {
+		t.Errorf("Incorrect URL %q", resp.URL)
+	}
+
+	if resp.HdURL != "https://apod.nasa.gov/apod/image/2107/PIA24542_fig2.jpg" {
+		t.Errorf("Incorrect HDURL %q", resp.HdURL)
+	}
+
+	if resp.Copyright != "" {
+		t.Errorf("Expected no copyright, got %q", resp.Copyright)
+	}
+
+	if resp.Explanation != "On sol 46 (April 6, 2021) the Perseverance rover held out a robotic arm to take its first selfie on Mars. The WATSON camera at the end of the arm was designed to take close-ups of martian rocks and surface details though, and not a quick snap shot of friends and smiling faces." {
+		t.Errorf("Incorrect explanation %q", resp.Explanation)
+	}
+}
+
+// Verify that copyrighted credits and entities are parsed
+func TestParsePageCopyright(t *testing.T) {
+	resp := parseFixture(t, "ap230101.html")
+
+	if resp.Title != "Fireworks Galaxy & Friends" {
+		t.Errorf("Incorrect title %q", resp.Title)
+	}
+
+	if resp.Copyright != "Jan Kuszaj, Anna Nowak" {
+		t.Errorf("Incorrect copyright %q", resp.Copyright)
+	}
+}
+
+// Verify that embedded videos are parsed
+func TestParsePageVideo(t *testing.T) {
+	resp := parseFixture(t, "ap220213.html")
+
+	if resp.MediaType != "video" {
+		t.Errorf("Incorrect media type %q", resp.MediaType)
+	}
+
+	if resp.URL != "https://www.youtube.com/embed/HEheh1BH34Q?rel=0" {
+		t.Errorf("Incorrect URL %q", resp.URL)
+	}
+
+	if resp.Thumbnail != "https://img.youtube.com/vi/HEheh1BH34Q/0.jpg" {
+		t.Errorf("Incorrect thumbnail %q", resp.Thumbnail)
+	}
+
+	if resp.Copyright != "Eclipse Chasers" {
+		t.Errorf("Incorrect copyright %q", resp.Copyright)
+	}
+}
+
+// Verify that Get and Today fall back to the archive when the API is down
+func TestFallback(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer api.Close()
+
+	archive := newArchiveServer(t)
+	scraper, err := NewScraper(archive.URL, archive.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apod := newTestClient(api.URL, WithFallback(scraper))
+
+	resp, err := apod.GetContext(context.Background(), "2023-01-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Title != "Fireworks Galaxy & Friends" {
+		t.Errorf("Incorrect title %q", resp.Title)
+	}
+
+	resp, err = apod.TodayContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Date != "2021-07-01" {
+		t.Errorf("Incorrect date %q", resp.Date)
+	}
+
+	// Pages missing from the archive report the API's error
+	if _, err = apod.GetContext(context.Background(), "2023-01-02"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+// Verify that responses from the archive aren't cached, so the API is asked again later
+func TestFallbackNotCached(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer api.Close()
+
+	archive := newArchiveServer(t)
+	scraper, err := NewScraper(archive.URL, archive.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(""), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apod := NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](),
+		WithBaseURL(api.URL), WithFallback(scraper))
+
+	if _, err := apod.GetContext(context.Background(), "2023-01-01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := apod.TodayContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, date := range []string{"2023-01-01", "2021-07-01"} {
+		if _, ok := apod.Cached(date); ok {
+			t.Errorf("%s: scraped response was cached", date)
+		}
+	}
+}
+
+// Verify that archive failures are reported as such, not as API failures
+func TestScrapeArchiveError(t *testing.T) {
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer archive.Close()
+
+	scraper, err := NewScraper(archive.URL, archive.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = scraper.Get(context.Background(), "2023-01-01")
+	var archiveErr *ArchiveError
+	if !errors.As(err, &archiveErr) || archiveErr.Page != "ap230101.html" {
+		t.Fatalf("expected an ArchiveError for ap230101.html, got %v", err)
+	}
+	if KindOf(err) != KindArchiveUnavailable {
+		t.Errorf("expected KindArchiveUnavailable, got %v", KindOf(err))
+	}
+	if !IsRetryable(err) {
+		t.Error("expected a 502 to be retryable")
+	}
+}
+
+// Verify that a hung archive is cut off by the request timeout too
+func TestFallbackTimeout(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	}))
+	defer api.Close()
+
+	done := make(chan struct{})
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer archive.Close()
+	defer close(done)
+
+	scraper, err := NewScraper(archive.URL, archive.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apod := newTestClient(api.URL, WithFallback(scraper), WithRequestTimeout(50*time.Millisecond))
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := apod.GetContext(context.Background(), "2023-01-01")
+		errs <- err
+	}()
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("expected an error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("fallback request was never cut off")
+	}
+}
@@ -0,0 +1,367 @@
+package apod
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+const (
+	// titleBoost is how much more a word in the title counts than one elsewhere
+	titleBoost = 3
+	// fieldGap separates the fields of a document so phrases can't span two of them
+	fieldGap = 8
+	// tagPrefix marks the terms that stand for tags, tokenize never produces it
+	tagPrefix = "#"
+
+	// BM25 parameters
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// posting records where a term appears in a document
+type posting struct {
+	// weight is the number of occurrences, boosted in the title
+	weight float64
+	// positions are the term's offsets in the document, for phrase queries
+	positions []int
+	// explanation is the number of occurrences in the explanation, for Related
+	explanation int
+}
+
+// document is an indexed APOD
+type document struct {
+	response *Response
+	tags     []string
+	length   int
+	terms    []string
+	// norm is the length of the explanation's term frequency vector
+	norm float64
+}
+
+// Index is an in-memory inverted index over the title, explanation and
+// copyright of APODs. It is safe for concurrent use
+type Index struct {
+	mu          sync.RWMutex
+	docs        map[string]*document
+	postings    map[string]map[string]*posting
+	totalLength int
+	// dates are the indexed dates in order, for drawing random ones
+	dates []string
+}
+
+// NewIndex creates an empty Index
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]*posting),
+	}
+}
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Add indexes an APOD and its tags, replacing any earlier version of the same date
+func (ix *Index) Add(r *Response, tags []string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if _, ok := ix.docs[r.Date]; !ok {
+		i := sort.SearchStrings(ix.dates, r.Date)
+		ix.dates = append(ix.dates, "")
+		copy(ix.dates[i+1:], ix.dates[i:])
+		ix.dates[i] = r.Date
+	}
+	ix.remove(r.Date)
+
+	postings := make(map[string]*posting)
+	position := 0
+	for _, field := range []struct {
+		text        string
+		weight      float64
+		explanation bool
+	}{
+		{r.Title, titleBoost, false},
+		{r.Explanation, 1, true},
+		{r.Copyright, 1, false},
+	} {
+		for _, term := range tokenize(field.text) {
+			p, ok := postings[term]
+			if !ok {
+				p = &posting{}
+				postings[term] = p
+			}
+			p.weight += field.weight
+			p.positions = append(p.positions, position)
+			if field.explanation {
+				p.explanation++
+			}
+			position++
+		}
+		position += fieldGap
+	}
+
+	// Tags are terms that match but don't score
+	for _, tag := range tags {
+		postings[tagPrefix+tag] = &posting{}
+	}
+
+	doc := &document{response: r, tags: tags, length: position}
+	for _, p := range postings {
+		if p.explanation > 0 {
+			doc.norm += termWeight(p.explanation) * termWeight(p.explanation)
+		}
+	}
+	doc.norm = math.Sqrt(doc.norm)
+
+	for term, p := range postings {
+		if ix.postings[term] == nil {
+			ix.postings[term] = make(map[string]*posting)
+		}
+		ix.postings[term][r.Date] = p
+		doc.terms = append(doc.terms, term)
+	}
+	ix.docs[r.Date] = doc
+	ix.totalLength += doc.length
+}
+
+// remove drops a date from the index, ix.mu must be held
+func (ix *Index) remove(date string) {
+	doc, ok := ix.docs[date]
+	if !ok {
+		return
+	}
+
+	for _, term := range doc.terms {
+		delete(ix.postings[term], date)
+		if len(ix.postings[term]) == 0 {
+			delete(ix.postings, term)
+		}
+	}
+	ix.totalLength -= doc.length
+	delete(ix.docs, date)
+}
+
+// Tags returns the tags of an indexed APOD, false if it isn't indexed
+func (ix *Index) Tags(date string) ([]string, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	doc, ok := ix.docs[date]
+	if !ok {
+		return nil, false
+	}
+	return doc.tags, true
+}
+
+// Len returns the number of indexed APODs
+func (ix *Index) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.docs)
+}
+
+// Query is a parsed search
+type Query struct {
+	// Terms must all appear in a result
+	Terms []string
+	// Phrases must appear word for word in a result
+	Phrases [][]string
+	// Tags must all be assigned to a result, see Classify
+	Tags []string
+	// From and To bound the date of a result (yyyy-mm-dd), inclusive, empty for no bound
+	From, To string
+}
+
+// ParseQuery parses a search like `"pillars of creation" hubble tag:nebula from:2010 to:2020-06-30`.
+// Quoted text is a phrase, tag: takes a tag, from: and to: take a year or a date
+func ParseQuery(text string) Query {
+	var q Query
+
+	for i, part := range strings.Split(text, `"`) {
+		// Odd parts were inside quotes
+		if i%2 == 1 {
+			if words := tokenize(part); len(words) > 1 {
+				q.Phrases = append(q.Phrases, words)
+			} else {
+				q.Terms = append(q.Terms, words...)
+			}
+			continue
+		}
+
+		for _, field := range strings.Fields(part) {
+			lower := strings.ToLower(field)
+			if value, ok := strings.CutPrefix(lower, "from:"); ok && isDateBound(value) {
+				q.From = value
+				if len(value) == 4 {
+					q.From += "-01-01"
+				}
+			} else if value, ok := strings.CutPrefix(lower, "to:"); ok && isDateBound(value) {
+				q.To = value
+				if len(value) == 4 {
+					q.To += "-12-31"
+				}
+			} else if tag, ok := strings.CutPrefix(lower, "tag:"); ok && tag != "" {
+				q.Tags = append(q.Tags, tag)
+			} else {
+				q.Terms = append(q.Terms, tokenize(field)...)
+			}
+		}
+	}
+
+	return q
+}
+
+// isDateBound checks if s is a year or a yyyy-mm-dd date
+func isDateBound(s string) bool {
+	if len(s) == 4 {
+		_, err := strconv.Atoi(s)
+		return err == nil
+	}
+	return IsValidDate(s)
+}
+
+// Result is a single search match
+type Result struct {
+	Response *Response
+	Score    float64
+}
+
+// Search returns up to limit APODs matching q, best first
+func (ix *Index) Search(q Query, limit int) []Result {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	terms := append([]string{}, q.Terms...)
+	for _, phrase := range q.Phrases {
+		terms = append(terms, phrase...)
+	}
+	required := append([]string{}, terms...)
+	for _, tag := range q.Tags {
+		required = append(required, tagPrefix+tag)
+	}
+	if len(required) == 0 || len(ix.docs) == 0 {
+		return nil
+	}
+
+	// Start from the rarest term, every term must match
+	sort.Slice(required, func(i, j int) bool {
+		return len(ix.postings[required[i]]) < len(ix.postings[required[j]])
+	})
+
+	var results []Result
+	avgLength := float64(ix.totalLength) / float64(len(ix.docs))
+	for date := range ix.postings[required[0]] {
+		if q.From != "" && date < q.From || q.To != "" && date > q.To {
+			continue
+		}
+		if !ix.matches(date, required, q.Phrases) {
+			continue
+		}
+
+		doc := ix.docs[date]
+		var score float64
+		for _, term := range terms {
+			df := float64(len(ix.postings[term]))
+			idf := math.Log(1 + (float64(len(ix.docs))-df+0.5)/(df+0.5))
+			tf := ix.postings[term][date].weight
+			score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.length)/avgLength))
+		}
+		results = append(results, Result{Response: doc.response, Score: score})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Response.Date > results[j].Response.Date
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matches checks that every term and phrase appears in date, ix.mu must be held
+func (ix *Index) matches(date string, terms []string, phrases [][]string) bool {
+	for _, term := range terms {
+		if _, ok := ix.postings[term][date]; !ok {
+			return false
+		}
+	}
+
+	for _, phrase := range phrases {
+		found := false
+		for _, start := range ix.postings[phrase[0]][date].positions {
+			found = true
+			for i, term := range phrase[1:] {
+				if !containsInt(ix.postings[term][date].positions, start+i+1) {
+					found = false
+					break
+				}
+			}
+			if found {
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// containsInt checks if the sorted slice s contains n
+func containsInt(s []int, n int) bool {
+	i := sort.SearchInts(s, n)
+	return i < len(s) && s[i] == n
+}
+
+// indexedCache calls onAdd with every response added to the cache it wraps
+type indexedCache struct {
+	cache.Cache[*Response]
+	onAdd func(*Response)
+}
+
+// Add a response to the cache, and to the index once the cache has it
+func (c *indexedCache) Add(date string, response *Response) error {
+	if err := c.Cache.Add(date, response); err != nil {
+		return err
+	}
+	c.onAdd(response)
+	return nil
+}
+
+// Dates lists the wrapped cache's dates, if it can list them
+func (c *indexedCache) Dates() []string {
+	if lister, ok := c.Cache.(cache.Lister); ok {
+		return lister.Dates()
+	}
+	return nil
+}
+
+// indexResponse tags and indexes a response that was just added to the cache
+func (a *APOD) indexResponse(r *Response) {
+	a.index.Add(r, a.retag(r))
+	a.credits.Add(r)
+}
+
+// Search finds cached APODs matching a query, see ParseQuery
+func (a *APOD) Search(query string, limit int) []Result {
+	return a.index.Search(ParseQuery(query), limit)
+}
+
+// SearchQuery is like Search for an already parsed query
+func (a *APOD) SearchQuery(q Query, limit int) []Result {
+	return a.index.Search(q, limit)
+}
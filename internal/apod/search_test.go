@@ -0,0 +1,101 @@
+package apod
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+func newSearchTestAPOD(t *testing.T) *APOD {
+	t.Helper()
+
+	cached := `{"date":"1995-09-05","title":"Pillars of Creation","explanation":"The Eagle Nebula's pillars of gas and dust, imaged by Hubble."}
+{"date":"2015-01-06","title":"The Pillars of the Eagle","explanation":"Hubble revisits the famous pillars of creation in the Eagle Nebula.","copyright":"NASA, ESA, Hubble Heritage Team"}
+{"date":"2020-05-01","title":"Moon over Andromeda","explanation":"The creation of this composite took many nights. Pillars of light rise from the city below."}
+`
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(cached), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](), WithFallback(nil))
+}
+
+func resultDates(results []Result) string {
+	var dates []string
+	for _, result := range results {
+		dates = append(dates, result.Response.Date)
+	}
+	return strings.Join(dates, " ")
+}
+
+// Verify ranking, phrases and date filters
+func TestSearch(t *testing.T) {
+	apod := newSearchTestAPOD(t)
+
+	tests := map[string]string{
+		// Title matches rank first
+		"pillars":                         "1995-09-05 2015-01-06 2020-05-01",
+		"hubble eagle":                    "2015-01-06 1995-09-05",
+		`"pillars of creation"`:           "1995-09-05 2015-01-06",
+		`"pillars of creation" from:2000`: "2015-01-06",
+		"pillars to:2014-12-31":           "1995-09-05",
+		"heritage":                        "2015-01-06",
+		"andromeda eagle":                 "",
+		"":                                "",
+	}
+	for query, expected := range tests {
+		if got := resultDates(apod.Search(query, 10)); got != expected {
+			t.Errorf("%q: expected %q, got %q", query, expected, got)
+		}
+	}
+
+	if got := resultDates(apod.Search("pillars", 1)); got != "1995-09-05" {
+		t.Errorf("expected the limit to apply, got %q", got)
+	}
+}
+
+// Verify that the index follows the cache
+func TestSearchUpdates(t *testing.T) {
+	apod := newSearchTestAPOD(t)
+
+	apod.cache.Add("2021-07-01", &Response{Date: "2021-07-01", Title: "Perseverance Selfie with Ingenuity"})
+	if got := resultDates(apod.Search("ingenuity", 10)); got != "2021-07-01" {
+		t.Errorf("expected the new APOD to be found, got %q", got)
+	}
+
+	// A corrected entry replaces the old one
+	apod.cache.Add("2021-07-01", &Response{Date: "2021-07-01", Title: "Perseverance and Ingenuity"})
+	if got := resultDates(apod.Search("selfie", 10)); got != "" {
+		t.Errorf("expected the old title to be gone, got %q", got)
+	}
+	if n := apod.index.Len(); n != 4 {
+		t.Errorf("expected 4 indexed APODs, got %d", n)
+	}
+}
+
+// failingCache is a cache that can't store anything
+type failingCache struct {
+	cache.Empty[*Response]
+}
+
+func (failingCache) Add(string, *Response) error {
+	return errors.New("disk full")
+}
+
+// Verify that responses the cache failed to store aren't indexed
+func TestSearchFailedAdd(t *testing.T) {
+	apod := NewClient("DEMO_KEY", &failingCache{}, cache.NewEmptyCache[*ImageWrapper](), WithFallback(nil))
+
+	if err := apod.cache.Add("2021-07-01", &Response{Date: "2021-07-01", Title: "Perseverance Selfie with Ingenuity", Copyright: "NASA"}); err == nil {
+		t.Fatal("expected the add to fail")
+	}
+	if n := apod.index.Len(); n != 0 {
+		t.Errorf("expected nothing to be indexed, got %d", n)
+	}
+	if _, dates := apod.Credits().Dates("nasa"); len(dates) != 0 {
+		t.Errorf("expected nothing to be credited, got %v", dates)
+	}
+}
@@ -0,0 +1,127 @@
+package apod
+
+import (
+	"slices"
+	"sort"
+	"strings"
+)
+
+// taxonomyVersion changes whenever Taxonomy does, so stored tags are recomputed
+const taxonomyVersion = 1
+
+// tagThreshold is the score a tag needs before it is assigned. A keyword in
+// the title scores titleTagWeight, one in the explanation scores 1, so a
+// single passing mention in the explanation isn't enough
+const (
+	tagThreshold   = 2
+	titleTagWeight = 3
+)
+
+// Taxonomy maps each tag to the keywords that suggest it. Keywords are lower
+// case and may be several words long
+var Taxonomy = map[string][]string{
+	"galaxy":       {"galaxy", "galaxies", "galactic", "andromeda", "spiral arms", "magellanic cloud", "magellanic clouds"},
+	"milky-way":    {"milky way"},
+	"nebula":       {"nebula", "nebulae", "nebulas", "planetary nebula", "supernova remnant"},
+	"star-cluster": {"star cluster", "star clusters", "globular cluster", "globular clusters", "open cluster", "open clusters", "pleiades"},
+	"supernova":    {"supernova", "supernovae", "supernova remnant", "hypernova"},
+	"black-hole":   {"black hole", "black holes", "event horizon", "quasar", "quasars"},
+	"planet":       {"planet", "planets", "exoplanet", "exoplanets", "mercury", "venus", "mars", "jupiter", "saturn", "uranus", "neptune", "pluto"},
+	"mars":         {"mars", "martian", "red planet", "jezero"},
+	"jupiter":      {"jupiter", "jovian", "great red spot", "io", "europa", "ganymede", "callisto"},
+	"saturn":       {"saturn", "titan", "enceladus"},
+	"moon":         {"moon", "lunar", "moonrise", "moonset", "moonlight"},
+	"sun":          {"sun", "solar", "sunspot", "sunspots", "sunrise", "sunset", "prominence", "solar flare", "coronal mass ejection"},
+	"eclipse":      {"eclipse", "eclipses", "eclipsed", "totality", "annular", "diamond ring"},
+	"aurora":       {"aurora", "aurorae", "auroras", "northern lights", "southern lights", "borealis", "australis"},
+	"comet":        {"comet", "comets", "cometary", "ion tail", "dust tail"},
+	"asteroid":     {"asteroid", "asteroids", "minor planet", "bennu", "ryugu", "vesta", "ceres"},
+	"meteor":       {"meteor", "meteors", "meteorite", "meteorites", "meteor shower", "fireball", "bolide", "perseids", "geminids", "leonids"},
+	"spacecraft":   {"spacecraft", "probe", "rover", "orbiter", "lander", "space station", "iss", "shuttle", "rocket", "astronaut", "astronauts", "voyager", "cassini"},
+}
+
+// TagNames lists every tag in Taxonomy, sorted
+func TagNames() []string {
+	names := make([]string, 0, len(Taxonomy))
+	for name := range Taxonomy {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Tags records the tags assigned to an APOD
+type Tags struct {
+	Date    string   `json:"date"`
+	Tags    []string `json:"tags"`
+	Version int      `json:"version"`
+}
+
+// GetDate is required to implement the cache package's `HasDate` interface
+func (t *Tags) GetDate() string {
+	return t.Date
+}
+
+// Has checks if tag is one of the tags
+func (t *Tags) Has(tag string) bool {
+	for _, have := range t.Tags {
+		if have == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify assigns tags to an APOD from the keywords in its title and explanation
+func Classify(r *Response) []string {
+	// Pad with spaces so keywords only match whole words
+	title := " " + strings.Join(tokenize(r.Title), " ") + " "
+	explanation := " " + strings.Join(tokenize(r.Explanation), " ") + " "
+
+	var tags []string
+	for _, tag := range TagNames() {
+		score := 0
+		for _, keyword := range Taxonomy[tag] {
+			keyword = " " + keyword + " "
+			score += titleTagWeight*strings.Count(title, keyword) + strings.Count(explanation, keyword)
+		}
+		if score >= tagThreshold {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// storedTags returns the stored tags of an APOD, classifying it first if it
+// hasn't been seen by the current taxonomy
+func (a *APOD) storedTags(r *Response) []string {
+	if tags, ok := a.tags.Get(r.Date); ok && tags.Version == taxonomyVersion {
+		return tags.Tags
+	}
+	return a.retag(r)
+}
+
+// retag classifies an APOD, storing its tags if they changed
+func (a *APOD) retag(r *Response) []string {
+	tags := &Tags{Date: r.Date, Tags: Classify(r), Version: taxonomyVersion}
+	if stored, ok := a.tags.Get(r.Date); !ok || stored.Version != tags.Version || !slices.Equal(stored.Tags, tags.Tags) {
+		a.tags.Add(r.Date, tags)
+	}
+	return tags.Tags
+}
+
+// Tags returns the tags of a cached APOD, false if it isn't cached
+func (a *APOD) Tags(date string) ([]string, bool) {
+	return a.index.Tags(date)
+}
+
+// hasTags checks if every tag in want is in tags
+func hasTags(tags, want []string) bool {
+	t := Tags{Tags: tags}
+	for _, tag := range want {
+		if !t.Has(tag) {
+			return false
+		}
+	}
+	return true
+}
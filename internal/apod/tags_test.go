@@ -0,0 +1,103 @@
+package apod
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+// Verify that tags come from the title, or repeated mentions in the explanation
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		response *Response
+		tags     []string
+	}{
+		{
+			&Response{Title: "Pillars of Creation", Explanation: "The Eagle Nebula's pillars of gas and dust."},
+			nil,
+		},
+		{
+			&Response{Title: "The Eagle Nebula", Explanation: "A star forming region."},
+			[]string{"nebula"},
+		},
+		{
+			// The Sun is only mentioned in passing
+			&Response{Title: "Total Solar Eclipse over Chile", Explanation: "During totality the Moon hides the Sun. The Moon's shadow raced across the land."},
+			[]string{"eclipse", "moon", "sun"},
+		},
+		{
+			&Response{Title: "Aurora over Iceland", Explanation: "The northern lights, seen from the ISS."},
+			[]string{"aurora"},
+		},
+		{
+			&Response{Title: "Perseverance Selfie with Ingenuity", Explanation: "The Perseverance rover on Mars. The rover is exploring Jezero crater."},
+			[]string{"mars", "spacecraft"},
+		},
+	}
+
+	for _, test := range tests {
+		if got := Classify(test.response); !slices.Equal(got, test.tags) {
+			t.Errorf("%q: expected %v, got %v", test.response.Title, test.tags, got)
+		}
+	}
+}
+
+// Verify that stored tags are reused, and that tags filter random and search
+func TestTags(t *testing.T) {
+	cached := `{"date":"2020-01-01","title":"The Eagle Nebula","media_type":"image","hdurl":"hd.jpg"}
+{"date":"2020-01-02","title":"Aurora over Iceland","media_type":"image","hdurl":"hd.jpg"}
+{"date":"2020-01-03","title":"Comet NEOWISE","media_type":"image","hdurl":"hd.jpg"}
+`
+	// 2020-01-03 was tagged by hand, 2020-01-02 by an older taxonomy
+	stored := `{"date":"2020-01-03","tags":["comet","aurora"],"version":1}
+{"date":"2020-01-02","tags":["galaxy"],"version":0}
+`
+	apodCache, err := cache.NewAppendCache[*Response](strings.NewReader(cached), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tagCache, err := cache.NewAppendCache[*Tags](strings.NewReader(stored), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apod := NewClient("DEMO_KEY", apodCache, cache.NewEmptyCache[*ImageWrapper](),
+		WithFallback(nil),
+		WithTagCache(tagCache),
+	)
+
+	expected := map[string][]string{
+		"2020-01-01": {"nebula"},
+		"2020-01-02": {"aurora"},
+		"2020-01-03": {"comet", "aurora"},
+	}
+	for date, tags := range expected {
+		if got, _ := apod.Tags(date); !slices.Equal(got, tags) {
+			t.Errorf("%s: expected %v, got %v", date, tags, got)
+		}
+		if stored, _ := tagCache.Get(date); !slices.Equal(stored.Tags, tags) {
+			t.Errorf("%s: expected %v to be stored, got %v", date, tags, stored.Tags)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		resp, err := apod.RandomWith(context.Background(), RandomFilter{Tags: []string{"aurora"}, FromYear: 2020})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Date != "2020-01-02" && resp.Date != "2020-01-03" {
+			t.Errorf("expected an aurora, got %s", resp.Date)
+		}
+	}
+
+	if got := resultDates(apod.Search("tag:aurora tag:comet", 10)); got != "2020-01-03" {
+		t.Errorf("expected only 2020-01-03, got %q", got)
+	}
+	if got := resultDates(apod.Search("tag:aurora", 10)); got != "2020-01-03 2020-01-02" {
+		t.Errorf("expected both auroras, newest first, got %q", got)
+	}
+}
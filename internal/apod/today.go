@@ -0,0 +1,85 @@
+package apod
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	// Embed the timezone database, the NASA timezone must load on any host
+	_ "time/tzdata"
+)
+
+const (
+	// rolloverRecheck is how often Today asks NASA for the new APOD once the
+	// publishing day has rolled over but the new picture isn't up yet
+	rolloverRecheck = 5 * time.Minute
+	// todayMaxAge is how long Today trusts an up to date response before asking
+	// NASA again, in case the entry was corrected during the day
+	todayMaxAge = 6 * time.Hour
+)
+
+// ErrorNotPublished is returned when the APOD for NASA's current day is not up yet
+var ErrorNotPublished = fmt.Errorf("today's APOD has not been published yet")
+
+// NASATimezone is the timezone APOD is published in, a new picture goes up
+// shortly after midnight US Eastern time
+var NASATimezone = mustLoadLocation("America/New_York")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// PublicationDate returns the APOD date (yyyy-mm-dd) that NASA considers
+// current at the instant t
+func PublicationDate(t time.Time) string {
+	return t.In(NASATimezone).Format("2006-01-02")
+}
+
+// TodayDate returns the date NASA considers today
+func (a *APOD) TodayDate() string {
+	return PublicationDate(a.now())
+}
+
+// isFresh checks if the in memory response for today can be served without
+// asking NASA again
+func (a *APOD) isFresh(current *Response, lastUpdate time.Time) bool {
+	if current == nil {
+		return false
+	}
+
+	age := a.now().Sub(lastUpdate)
+	if current.Date >= a.TodayDate() {
+		return age < todayMaxAge
+	}
+
+	// The day rolled over, look for the new picture every few minutes
+	return age < rolloverRecheck
+}
+
+// PublishedTodayContext is like TodayContext but returns ErrorNotPublished,
+// along with the most recent APOD, while NASA has yet to publish today's picture
+//
+// Unlike TodayContext it asks NASA again on every call until the new picture is up
+func (a *APOD) PublishedTodayContext(ctx context.Context) (*Response, error) {
+	a.mu.RLock()
+	current, lastUpdate := a.current, a.lastUpdate
+	a.mu.RUnlock()
+
+	if a.isFresh(current, lastUpdate) && current.Date >= a.TodayDate() {
+		return current, nil
+	}
+
+	response, err := a.refreshToday(ctx)
+	if err != nil {
+		return response, err
+	}
+
+	if response.Date < a.TodayDate() {
+		return response, ErrorNotPublished
+	}
+	return response, nil
+}
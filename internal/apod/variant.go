@@ -0,0 +1,135 @@
+package apod
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"math"
+)
+
+// SizeProfile names a size an image is cached at
+type SizeProfile string
+
+const (
+	// ProfileOriginal is the image as it was downloaded
+	ProfileOriginal SizeProfile = "original"
+	// Profile8MB fits discord's default upload limit
+	Profile8MB SizeProfile = "8mb"
+	// Profile25MB fits the upload limit of level 2 boosted servers
+	Profile25MB SizeProfile = "25mb"
+	// Profile50MB fits the upload limit of level 3 boosted servers
+	Profile50MB SizeProfile = "50mb"
+	// ProfileThumbnail is a small still jpeg for previews
+	ProfileThumbnail SizeProfile = "thumbnail"
+)
+
+const (
+	// thumbnailDimension is the longest side of a thumbnail
+	thumbnailDimension = 320
+	// thumbnailQuality is the jpeg quality of thumbnails
+	thumbnailQuality = 85
+)
+
+// MaxBytes returns the largest an image of the profile may be, 0 for no limit
+func (p SizeProfile) MaxBytes() int {
+	switch p {
+	case Profile8MB:
+		return 8 << 20
+	case Profile25MB:
+		return 25 << 20
+	case Profile50MB:
+		return 50 << 20
+	case ProfileThumbnail:
+		return 256 << 10
+	}
+	return 0
+}
+
+// variantKey is where a variant is cached, next to the original at date
+func variantKey(date string, profile SizeProfile, format string) string {
+	extension := format
+	if format == "jpeg" {
+		extension = "jpg"
+	}
+	return fmt.Sprintf("%s-%s.%s", date, profile, extension)
+}
+
+// GetImageVariant returns the image for a specific day at a size profile.
+// Variants are built from the original once and cached next to it, the
+// original is never changed. An original that already fits is returned as is
+func (a *APOD) GetImageVariant(ctx context.Context, day string, profile SizeProfile) (*ImageWrapper, error) {
+	if profile == ProfileOriginal {
+		return a.GetImageContext(ctx, day)
+	}
+	if profile.MaxBytes() == 0 {
+		return nil, fmt.Errorf("unknown size profile %q", profile)
+	}
+
+	// Variants are jpegs, or gifs when the original is animated
+	for _, format := range []string{"jpeg", "gif"} {
+		if img, ok := a.imageCache.Get(variantKey(day, profile, format)); ok {
+			return img, nil
+		}
+	}
+
+	original, err := a.GetImageContext(ctx, day)
+	if err != nil {
+		return nil, err
+	}
+	if profile != ProfileThumbnail && len(original.Bytes) <= profile.MaxBytes() {
+		return original, nil
+	}
+
+	// Concurrent requests for the same variant share a single resize
+	return a.variants.do(ctx, day+"-"+string(profile), func(ctx context.Context) (*ImageWrapper, error) {
+		var variant *ImageWrapper
+		var err error
+		if profile == ProfileThumbnail {
+			variant, err = original.Thumbnail(thumbnailDimension, profile.MaxBytes())
+		} else {
+			variant, err = original.Resized(profile.MaxBytes())
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		a.imageCache.Add(variantKey(day, profile, variant.Format), variant)
+		return variant, nil
+	})
+}
+
+// Resized is like Resize but returns a new ImageWrapper, leaving i as it is
+func (i *ImageWrapper) Resized(maxBytes int) (*ImageWrapper, error) {
+	// Resize replaces the fields, it never writes into the image or its bytes
+	resized := *i
+	if err := resized.Resize(maxBytes); err != nil {
+		return nil, err
+	}
+	return &resized, nil
+}
+
+// Thumbnail returns a still jpeg of the image scaled to fit in a
+// maxDimension square, and at most maxBytes. Animated GIFs use their first frame
+func (i *ImageWrapper) Thumbnail(maxDimension, maxBytes int) (*ImageWrapper, error) {
+	if i.Image == nil {
+		return nil, fmt.Errorf("%w: the image could not be decoded", ErrorImageTooLarge)
+	}
+
+	img := encodable(i.Image)
+	bounds := img.Bounds()
+	if longest := max(bounds.Dx(), bounds.Dy()); longest > maxDimension {
+		ratio := float64(maxDimension) / float64(longest)
+		w := max(int(math.Round(float64(bounds.Dx())*ratio)), 1)
+		h := max(int(math.Round(float64(bounds.Dy())*ratio)), 1)
+		img = scaleImage(img, w, h)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := jpeg.Encode(buf, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		return nil, err
+	}
+
+	thumbnail := &ImageWrapper{Image: img, Format: "jpeg", Bytes: buf.Bytes()}
+	return thumbnail.Resized(maxBytes)
+}
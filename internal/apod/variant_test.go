@@ -0,0 +1,51 @@
+package apod
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/Alextopher/apod-bot/internal/cache"
+)
+
+// Verify that variants are cached next to the original without changing it
+func TestImageVariants(t *testing.T) {
+	images := cache.NewFSCache(cache.NewInMemoryFS(), NewImageWrapper, func(iw *ImageWrapper) ([]byte, error) {
+		return iw.Bytes, nil
+	})
+	fixture := loadFixture(t, "nebula.jpg")
+	original := bytes.Clone(fixture.Bytes)
+	images.Add("2021-07-01", fixture)
+
+	apod := NewClient("DEMO_KEY", cache.NewEmptyCache[*Response](), images, WithFallback(nil))
+
+	thumbnail, err := apod.GetImageVariant(context.Background(), "2021-07-01", ProfileThumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds := thumbnail.Image.Bounds(); max(bounds.Dx(), bounds.Dy()) != thumbnailDimension || thumbnail.Format != "jpeg" {
+		t.Errorf("expected a %d pixel jpeg, got a %v %s", thumbnailDimension, bounds, thumbnail.Format)
+	}
+	if len(thumbnail.Bytes) > ProfileThumbnail.MaxBytes() {
+		t.Errorf("expected at most %d bytes, got %d", ProfileThumbnail.MaxBytes(), len(thumbnail.Bytes))
+	}
+	if !images.Has("2021-07-01-thumbnail.jpg") {
+		t.Error("expected the thumbnail to be cached")
+	}
+
+	// The original already fits in 8MB
+	fits, err := apod.GetImageVariant(context.Background(), "2021-07-01", Profile8MB)
+	if err != nil || !bytes.Equal(fits.Bytes, original) {
+		t.Error("expected the original", err)
+	}
+
+	resized, err := fixture.Resized(len(original) / 4)
+	if err != nil || len(resized.Bytes) > len(original)/4 {
+		t.Error("expected a smaller copy", err)
+	}
+
+	cached, _ := images.Get("2021-07-01")
+	if !bytes.Equal(cached.Bytes, original) || !bytes.Equal(fixture.Bytes, original) {
+		t.Error("expected the original to be left alone")
+	}
+}
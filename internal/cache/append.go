@@ -3,6 +3,7 @@ package cache
 import (
 	"encoding/json"
 	"io"
+	"sort"
 	"sync"
 )
 
@@ -65,3 +66,15 @@ func (c *AppendOnly[T]) Has(date string) bool {
 	c.RUnlock()
 	return ok
 }
+
+// Dates lists every day in the cache, in order
+func (c *AppendOnly[T]) Dates() []string {
+	c.RLock()
+	dates := make([]string, 0, len(c.cache))
+	for date := range c.cache {
+		dates = append(dates, date)
+	}
+	c.RUnlock()
+	sort.Strings(dates)
+	return dates
+}
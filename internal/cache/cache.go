@@ -12,6 +12,11 @@ type Cache[T any] interface {
 	Has(string) bool
 }
 
+// Lister is implemented by caches that can list what they hold
+type Lister interface {
+	Dates() []string
+}
+
 // AddAll is a helper function to add a list of responses to a cache.
 func AddAll[T HasDate](c Cache[T], responses []T) error {
 	for _, response := range responses {
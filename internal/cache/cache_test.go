@@ -24,3 +24,9 @@ func TestEmptyImplementsCache(t *testing.T) {
 func TestFSCacheImplementsCache(t *testing.T) {
 	var _ Cache[Dummy] = &FSCache[Dummy]{}
 }
+
+// Verify that AppendOnly and Empty implement the Lister interface
+func TestListers(t *testing.T) {
+	var _ Lister = &AppendOnly[Dummy]{}
+	var _ Lister = &Empty[Dummy]{}
+}
@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// DataDir is the directory the bot keeps its database and state files in, so
+// that they can be mounted as one directory. A file mounted on its own can't
+// be replaced by renaming another over it.
+const DataDir = "data"
+
+// DataPath returns the path of a file in DataDir, moving the file there from
+// the working directory where earlier versions kept it.
+func DataPath(name string) (string, error) {
+	path := filepath.Join(DataDir, name)
+	if err := MoveLegacy(name, path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// MoveLegacy moves the file at legacy to path, creating the directory of path
+// as needed. Nothing is moved if legacy doesn't exist or path already does.
+func MoveLegacy(legacy, path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if _, err := os.Stat(legacy); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	log.Printf("Moving %s to %s\n", legacy, path)
+	return os.Rename(legacy, path)
+}
@@ -0,0 +1,34 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Verify that files from earlier versions are moved, but never over newer ones
+func TestMoveLegacy(t *testing.T) {
+	dir := t.TempDir()
+	legacy, path := filepath.Join(dir, "apod.db"), filepath.Join(dir, "data", "apod.db")
+
+	// Nothing to move
+	if err := MoveLegacy(legacy, path); err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(legacy, []byte("old"), 0644)
+	if err := MoveLegacy(legacy, path); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "old" {
+		t.Errorf("expected the file to be moved, got %q %v", data, err)
+	}
+
+	os.WriteFile(legacy, []byte("stale"), 0644)
+	if err := MoveLegacy(legacy, path); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Errorf("expected the moved file to be kept, got %q", data)
+	}
+}
@@ -23,3 +23,8 @@ func (c *Empty[T]) Get(date string) (T, bool) {
 func (c *Empty[T]) Has(date string) bool {
 	return false
 }
+
+// Dates lists every day in the cache, which is none
+func (c *Empty[T]) Dates() []string {
+	return nil
+}
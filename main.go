@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -20,8 +21,10 @@ func main() {
 	// Load tokens from .env file.
 	discordToken := os.Getenv("DISCORD_TOKEN")
 	apodToken := os.Getenv("APOD_TOKEN")
+	// Additional comma separated NASA API keys to rotate through
+	apodTokens := apod.ParseKeys(os.Getenv("APOD_TOKENS"))
 
-	if discordToken == "" || apodToken == "" {
+	if discordToken == "" || (apodToken == "" && len(apodTokens) == 0) {
 		log.Println("Please set DISCORD_TOKEN and APOD_TOKEN in the .env file.")
 		return
 	}
@@ -33,19 +36,19 @@ func main() {
 		return
 	}
 
-	// Create reader and writer for the database
-	f, err := os.OpenFile("apod.db", os.O_RDWR|os.O_CREATE, 0644)
+	// Open the database, moving it into the data directory if needed
+	dbPath, err := cache.DataPath("apod.db")
 	if err != nil {
-		log.Println("Error opening apod.db: ", err)
+		log.Println("Error moving apod.db: ", err)
 		return
 	}
-	defer f.Close()
 
-	db, err := NewDB(f, f)
+	db, err := OpenDB(dbPath)
 	if err != nil {
-		log.Println("Error creating database: ", err)
+		log.Println("Error opening database: ", err)
 		return
 	}
+	defer db.Close()
 
 	// Connect to APOD API
 	cacheFile, err := os.OpenFile("apod.cache", os.O_RDWR|os.O_CREATE, 0644)
@@ -61,12 +64,82 @@ func main() {
 		return
 	}
 
+	// Dates with no APOD
+	missingPath, err := cache.DataPath("apod.missing")
+	if err != nil {
+		log.Println("Error moving apod.missing: ", err)
+		return
+	}
+
+	missingFile, err := os.OpenFile(missingPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println("Error opening apod.missing: ", err)
+		return
+	}
+
+	missingCache, err := cache.NewAppendCache[*apod.MissingDate](missingFile, missingFile)
+	if err != nil {
+		log.Println("Error creating missing dates cache: ", err)
+		return
+	}
+
+	// Topic tags of each APOD
+	tagPath, err := cache.DataPath("apod.tags")
+	if err != nil {
+		log.Println("Error moving apod.tags: ", err)
+		return
+	}
+
+	tagFile, err := os.OpenFile(tagPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println("Error opening apod.tags: ", err)
+		return
+	}
+
+	tagCache, err := cache.NewAppendCache[*apod.Tags](tagFile, tagFile)
+	if err != nil {
+		log.Println("Error creating tag cache: ", err)
+		return
+	}
+
+	// Optionally point the client at an APOD API mirror
+	apodOptions := []apod.Option{apod.WithKeys(apodTokens...), apod.WithMissingCache(missingCache), apod.WithTagCache(tagCache)}
+	if baseURL, ok := os.LookupEnv("APOD_BASE_URL"); ok {
+		apodOptions = append(apodOptions, apod.WithBaseURL(baseURL))
+	}
+
+	// Note the big downloads, they're the ones that get resized
+	apodOptions = append(apodOptions, apod.WithDownloadProgress(func(progress apod.DownloadProgress) {
+		if progress.Done && progress.Read > int64(discordProfile.MaxBytes()) {
+			log.Printf("Downloaded %s (%.1f MB)\n", progress.URL, float64(progress.Read)/(1<<20))
+		}
+	}))
+
 	bot := &Bot{
 		db:      db,
-		apod:    apod.NewClient(apodToken, apodCache, imageCache),
 		session: session,
 	}
 
+	// Tell the owner when NASA rejects a key, once each time it is benched
+	apodOptions = append(apodOptions, apod.WithKeyRejected(func(key apod.KeyStatus) {
+		go bot.MessageOwner("NASA rejected an API key: " + key.String())
+	}))
+
+	client := apod.NewClient(apodToken, apodCache, imageCache, apodOptions...)
+
+	// Fill the cache in the background, resuming from data/backfill.json
+	if _, err := cache.DataPath("backfill.json"); err != nil {
+		log.Println("Error moving backfill.json: ", err)
+		return
+	}
+
+	backfillOptions := apod.DefaultBackfillOptions
+	backfillOptions.Images = os.Getenv("BACKFILL_IMAGES") == "true"
+	backfillOptions.State = cache.NewLocalFS(cache.DataDir)
+
+	bot.apod = client
+	bot.backfill = apod.NewBackfill(client, backfillOptions)
+
 	// Set the bot's owner
 	if owner, ok := os.LookupEnv("OWNER"); ok {
 		err = bot.SetOwner(owner)
@@ -102,8 +175,8 @@ func main() {
 
 	<-ch
 
-	// Handle application commands
-	session.AddHandler(bot.commandHandler)
+	// Handle application commands and buttons
+	session.AddHandler(bot.interactionHandler)
 
 	// Announce when the bot joins a guild.
 	session.AddHandler(func(s *discordgo.Session, event *discordgo.GuildCreate) {
@@ -148,10 +221,11 @@ func main() {
 	}
 
 	log.Println("Bot is running. Press CTRL-C to exit.")
-	go bot.RunScheduler()
-	go bot.apod.Fill()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	go bot.RunScheduler(ctx)
+	go bot.backfill.Run(ctx)
 
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
-	<-stop
+	<-ctx.Done()
 }
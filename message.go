@@ -104,7 +104,7 @@ func (r *Response) TextMessage(content string, flags discordgo.MessageFlags) err
 	})
 }
 
-// EmbedMessage responds to an interaction with an embed message, and files
+// EmbedMessage responds to an interaction with an embed message, and a file if it isn't nil
 func (r *Response) EmbedMessage(embed *discordgo.MessageEmbed, file *discordgo.File, flags discordgo.MessageFlags) error {
 	r.Lock()
 	defer r.Unlock()
@@ -116,10 +116,15 @@ func (r *Response) EmbedMessage(embed *discordgo.MessageEmbed, file *discordgo.F
 	r.cancel()
 	r.finished = true
 
+	var files []*discordgo.File
+	if file != nil {
+		files = append(files, file)
+	}
+
 	if r.deferred {
 		_, err := r.session.InteractionResponseEdit(r.interaction, &discordgo.WebhookEdit{
 			Embeds: &[]*discordgo.MessageEmbed{embed},
-			Files:  []*discordgo.File{file},
+			Files:  files,
 		})
 		return err
 	}
@@ -128,8 +133,38 @@ func (r *Response) EmbedMessage(embed *discordgo.MessageEmbed, file *discordgo.F
 		Type: discordgo.InteractionResponseChannelMessageWithSource,
 		Data: &discordgo.InteractionResponseData{
 			Embeds: []*discordgo.MessageEmbed{embed},
-			Files:  []*discordgo.File{file},
+			Files:  files,
 			Flags:  flags,
 		},
 	})
 }
+
+// ComponentMessage responds to an interaction with a text message and components, such as buttons
+func (r *Response) ComponentMessage(content string, components []discordgo.MessageComponent, flags discordgo.MessageFlags) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.finished {
+		return ErrResponseSent
+	}
+
+	r.cancel()
+	r.finished = true
+
+	if r.deferred {
+		_, err := r.session.InteractionResponseEdit(r.interaction, &discordgo.WebhookEdit{
+			Content:    &content,
+			Components: &components,
+		})
+		return err
+	}
+
+	return r.session.InteractionRespond(r.interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:    content,
+			Components: components,
+			Flags:      flags,
+		},
+	})
+}